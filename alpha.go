@@ -0,0 +1,46 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// premultiplied returns img with each colour channel multiplied by its
+// alpha. chai2010/webp hands an *image.RGBA's bytes to libwebp unchanged,
+// so the premultiplied values are what the file stores.
+func premultiplied(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+// hasAlphaChannel reports whether img's pixels carry alpha: a
+// non-premultiplied or YCbCrA type, or a palette with a translucent entry.
+// The premultiplied types are left out because image/png decodes plain
+// truecolor to them.
+func hasAlphaChannel(img image.Image) bool {
+	switch m := img.ColorModel().(type) {
+	case color.Palette:
+		for _, c := range m {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+		return false
+	}
+	switch img.ColorModel() {
+	case color.NRGBAModel, color.NRGBA64Model, color.NYCbCrAModel:
+		return true
+	}
+	return false
+}
+
+// isOpaque reports whether every pixel of img is fully opaque. libwebp
+// leaves the alpha plane out of such images, so an opaque RGBA PNG costs
+// no more than an RGB one.
+func isOpaque(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
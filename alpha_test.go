@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+// storedPixel writes a 4x4 image of c through writeWebp and returns the
+// values the file stores, as x/image/webp reports them without conversion
+func storedPixel(t *testing.T, c color.NRGBA, premultiply bool) color.NRGBA {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	out := filepath.Join(t.TempDir(), "a.webp")
+	o := convertOptions{lossless: true, quality: 100, overwrite: true, premultiplyOutput: premultiply}
+	var stats convertStats
+	if err := writeWebp(img, out, o, &stats); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := xwebp.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ok := dec.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.NRGBA", dec)
+	}
+	return n.NRGBAAt(0, 0)
+}
+
+func TestPremultiplyOutputRoundTrip(t *testing.T) {
+	c := color.NRGBA{200, 100, 50, 128}
+	// c·a/255, rounded down as image/draw does
+	want := color.NRGBA{100, 50, 25, 128}
+	if got := storedPixel(t, c, true); got != want {
+		t.Errorf("--premultiply-output stored %v, want %v", got, want)
+	}
+}
+
+func TestOpaqueAlphaNotStored(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 16), 90, 255})
+		}
+	}
+	for _, lossless := range []bool{false, true} {
+		out := filepath.Join(t.TempDir(), "a.webp")
+		o := convertOptions{lossless: lossless, quality: 80, overwrite: true}
+		stats := convertStats{srcAlpha: hasAlphaChannel(img)}
+		if err := writeWebp(img, out, o, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if !stats.opaqueAlpha {
+			t.Errorf("lossless=%t: opaque NRGBA not reported", lossless)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A simple-format file has just a VP8 or VP8L chunk, and VP8L keeps
+		// its alpha_is_used bit at bit 28 of the header after the signature
+		switch chunk := string(data[12:16]); {
+		case chunk == "VP8 ":
+		case chunk == "VP8L" && data[24]&0x10 == 0:
+		default:
+			t.Errorf("lossless=%t: output stores alpha (%q chunk)", lossless, chunk)
+		}
+	}
+
+	// image/png decodes truecolor without alpha to *image.RGBA
+	for _, noAlpha := range []image.Image{
+		image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420),
+		image.NewRGBA(image.Rect(0, 0, 4, 4)),
+	} {
+		if hasAlphaChannel(noAlpha) {
+			t.Errorf("%T reported as having alpha", noAlpha)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+)
+
+// chai2010/webp has no Go binding for the animation decoder, so animated
+// files are split by hand: each ANMF frame's bitstream is re-wrapped as a
+// standalone WebP, decoded, and composited onto the canvas following the
+// frame's blend and dispose flags.
+
+// frameOutputPattern matches files written by --extract-frames
+var frameOutputPattern = regexp.MustCompile(`(?i)_frame_\d+\.webp$`)
+
+// riffChunk is one chunk of a WebP RIFF container
+type riffChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// readWebPChunks splits a WebP file into its top-level chunks
+func readWebPChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	return readChunks(data[12:])
+}
+
+// readChunks parses a sequence of RIFF chunks (each padded to even size)
+func readChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			return nil, fmt.Errorf("%w: chunk %q overruns file", errTruncated, string(data[0:4]))
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(data[0:4]), payload: data[8 : 8+size]})
+		next := 8 + size + size&1
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+// writeChunk appends a chunk with its padding byte
+func writeChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)&1 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// uint24 reads a little-endian 24-bit value
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// putUint24 writes a little-endian 24-bit value
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// decodeFrameBitstream wraps a frame's ALPH/VP8/VP8L chunks as a standalone
+// WebP and decodes it
+func decodeFrameBitstream(chunks []riffChunk, w, h int) (image.Image, error) {
+	var body bytes.Buffer
+	hasAlpha := false
+	for _, c := range chunks {
+		if c.fourCC == "ALPH" {
+			hasAlpha = true
+		}
+	}
+	if hasAlpha {
+		// ALPH is only valid in an extended (VP8X) file
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		putUint24(vp8x[4:7], w-1)
+		putUint24(vp8x[7:10], h-1)
+		writeChunk(&body, "VP8X", vp8x)
+	}
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "ALPH", "VP8 ", "VP8L":
+			writeChunk(&body, c.fourCC, c.payload)
+		}
+	}
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(4+body.Len()))
+	file.WriteString("WEBP")
+	file.Write(body.Bytes())
+	return webp.Decode(&file)
+}
+
+// decodeWebPFrames returns every fully composited frame of a WebP file.
+// Still images yield a single frame.
+func decodeWebPFrames(data []byte) ([]image.Image, error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var canvas *image.RGBA
+	var frames []image.Image
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "VP8X":
+			if len(c.payload) < 10 {
+				return nil, fmt.Errorf("%w: short VP8X chunk", errTruncated)
+			}
+			canvas = image.NewRGBA(image.Rect(0, 0, uint24(c.payload[4:7])+1, uint24(c.payload[7:10])+1))
+		case "ANMF":
+			if canvas == nil || len(c.payload) < 16 {
+				return nil, fmt.Errorf("%w: malformed ANMF chunk", errDecode)
+			}
+			p := c.payload
+			x, y := uint24(p[0:3])*2, uint24(p[3:6])*2
+			w, h := uint24(p[6:9])+1, uint24(p[9:12])+1
+			noBlend := p[15]&0x02 != 0
+			dispose := p[15]&0x01 != 0
+
+			sub, err := readChunks(p[16:])
+			if err != nil {
+				return nil, err
+			}
+			frame, err := decodeFrameBitstream(sub, w, h)
+			if err != nil {
+				return nil, fmt.Errorf("frame %d: %w", len(frames), err)
+			}
+
+			rect := image.Rect(x, y, x+w, y+h)
+			op := draw.Over
+			if noBlend {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, frame, frame.Bounds().Min, op)
+
+			snapshot := image.NewRGBA(canvas.Bounds())
+			copy(snapshot.Pix, canvas.Pix)
+			frames = append(frames, snapshot)
+
+			if dispose {
+				draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if len(frames) == 0 {
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+	return frames, nil
+}
+
+// isAnimatedWebP reports whether data is a WebP with animation frames
+func isAnimatedWebP(data []byte) bool {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		if c.fourCC == "ANMF" {
+			return true
+		}
+	}
+	return false
+}
+
+// webpAnimTiming returns the per-frame durations (ms) and play count
+// (0 = forever) of an animated WebP
+func webpAnimTiming(data []byte) (delays []int, plays int, err error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "ANIM":
+			if len(c.payload) >= 6 {
+				plays = int(binary.LittleEndian.Uint16(c.payload[4:6]))
+			}
+		case "ANMF":
+			if len(c.payload) < 16 {
+				return nil, 0, fmt.Errorf("%w: malformed ANMF chunk", errDecode)
+			}
+			delays = append(delays, uint24(c.payload[12:15]))
+		}
+	}
+	return delays, plays, nil
+}
+
+// writeAnimatedThumbnail scales every frame of an animated WebP by
+// --thumbnail and writes them as an animated WebP with the same timing
+func writeAnimatedThumbnail(data []byte, thumbPath string, opts convertOptions) error {
+	frames, err := decodeWebPFrames(data)
+	if err != nil {
+		return err
+	}
+	delays, plays, err := webpAnimTiming(data)
+	if err != nil {
+		return err
+	}
+	b := frames[0].Bounds()
+	w := max(1, b.Dx()*opts.thumbnailPercent/100)
+	h := max(1, b.Dy()*opts.thumbnailPercent/100)
+	scaled := make([]*image.RGBA, len(frames))
+	for i, f := range frames {
+		scaled[i] = image.NewRGBA(image.Rect(0, 0, w, h))
+		scaleInto(scaled[i], f, opts.progressiveDownscale)
+	}
+	out, err := encodeAnimatedWebP(scaled, delays, plays, opts)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(thumbPath, out)
+}
+
+// runExtractFrames writes each frame of every .webp under --directory as
+// name_frame_NN.webp
+func runExtractFrames() error {
+	files, err := collectWebpFiles(opts.directory, opts.recursive)
+	if err != nil {
+		return fmt.Errorf("error collecting .webp files: %w", err)
+	}
+
+	extracted := 0
+	failed := 0
+	for _, p := range files {
+		if frameOutputPattern.MatchString(p) {
+			continue
+		}
+		n, err := extractFrames(p)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "[FAIL]\t%s [%s]: %v\n", p, errorCode(err), err)
+			continue
+		}
+		extracted += n
+		fmt.Fprintf(runLog, "[FRAMES]\t%s: %d\n", p, n)
+	}
+	fmt.Fprintf(runLog, "Done. Frames: %d, Failed: %d\n", extracted, failed)
+	return nil
+}
+
+// extractFrames decodes all frames of one file and writes them out
+func extractFrames(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, openError(err)
+	}
+	frames, err := decodeWebPFrames(data)
+	if err != nil {
+		return 0, decodeError(err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dir := mirrorDir(filepath.Dir(path))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, writeError(err)
+	}
+	width := max(2, len(fmt.Sprint(len(frames)-1)))
+	for i, frame := range frames {
+		outPath := filepath.Join(dir, fmt.Sprintf("%s_frame_%0*d.webp", base, width, i))
+		if !opts.overwrite {
+			if _, err := os.Stat(outPath); err == nil {
+				continue
+			}
+		}
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, frame, &webp.Options{Lossless: opts.lossless, Quality: opts.quality}); err != nil {
+			return i, encodeError("frame", err)
+		}
+		if err := writeFileAtomic(outPath, buf.Bytes()); err != nil {
+			return i, writeError(err)
+		}
+	}
+	return len(frames), nil
+}
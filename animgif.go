@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+
+	webp "github.com/chai2010/webp"
+)
+
+// Animated GIFs are written by --anim-format as animated WebP or APNG.
+// GIF disposal is applied while compositing, so every output frame is a
+// full-canvas keyframe and both formats replay exactly as composited:
+//
+//   - webp: frames are encoded with --quality/--lossless and muxed into
+//     ANMF chunks with blending off; loop count and per-frame delays are kept.
+//   - apng: frames are always lossless RGBA; loop count and per-frame delays
+//     are kept. --quality does not apply.
+//
+// Only --width/--height resizing is applied to animations; trim, ROI,
+// aspect fixes, colour quantisation and thumbnails are not.
+
+// gifAnimation is a composited multi-frame GIF
+type gifAnimation struct {
+	frames []*image.RGBA
+	delays []int // per frame, in milliseconds
+	plays  int   // total plays, 0 = forever
+}
+
+// composeGIF composites every frame of a multi-frame GIF
+func composeGIF(g *gif.GIF) *gifAnimation {
+	anim := &gifAnimation{}
+	// GIF counts repeats after the first play; -1 means play once
+	switch {
+	case g.LoopCount == 0:
+		anim.plays = 0
+	case g.LoopCount < 0:
+		anim.plays = 1
+	default:
+		anim.plays = g.LoopCount + 1
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		snapshot := image.NewRGBA(canvas.Bounds())
+		copy(snapshot.Pix, canvas.Pix)
+		anim.frames = append(anim.frames, snapshot)
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i] * 10
+		}
+		anim.delays = append(anim.delays, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return anim
+}
+
+// writeAnimation resizes the frames per --width/--height and
+// --max-output-dim and writes them
+// to outPath in opts.animFormat
+func writeAnimation(anim *gifAnimation, outPath string, opts convertOptions, stats *convertStats) error {
+	frames := anim.frames
+	b := frames[0].Bounds()
+	w, h := fitWithin(b.Dx(), b.Dy(), opts.maxWidth, opts.maxHeight)
+	if opts.maxOutputDim > 0 {
+		w, h = clampToDim(w, h, opts.maxOutputDim)
+	}
+	if w != b.Dx() || h != b.Dy() {
+		frames = make([]*image.RGBA, len(anim.frames))
+		for i, f := range anim.frames {
+			frames[i] = image.NewRGBA(image.Rect(0, 0, w, h))
+			scaleInto(frames[i], f, opts.progressiveDownscale)
+		}
+		stats.resized = true
+	}
+	stats.outWidth, stats.outHeight = w, h
+	outPath = expandDimensions(outPath, w, h)
+	stats.outPath = outPath
+
+	var data []byte
+	var err error
+	if opts.animFormat == "apng" {
+		data, err = encodeAPNG(frames, anim.delays, anim.plays)
+		stats.lossless = true
+	} else {
+		data, err = encodeAnimatedWebP(frames, anim.delays, anim.plays, opts)
+		stats.lossless = opts.lossless
+		stats.quality = opts.quality
+	}
+	if err != nil {
+		return err
+	}
+	stats.outBytes = int64(len(data))
+	if opts.contentAddressed {
+		outPath = contentAddressedPath(outPath, data)
+		stats.outPath = outPath
+	}
+	if opts.noWrite {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return writeError(err)
+	}
+	if err := writeFileAtomic(outPath, data); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// fitWithin scales w x h down to fit maxW x maxH (0 = no limit), keeping
+// the aspect ratio
+func fitWithin(w, h, maxW, maxH int) (int, int) {
+	nw, nh := w, h
+	if maxW > 0 && nw > maxW {
+		nh = max(1, nh*maxW/nw)
+		nw = maxW
+	}
+	if maxH > 0 && nh > maxH {
+		nw = max(1, nw*maxH/nh)
+		nh = maxH
+	}
+	return nw, nh
+}
+
+// encodeAnimatedWebP muxes still WebP encodes of each frame into an
+// animated WebP container
+func encodeAnimatedWebP(frames []*image.RGBA, delays []int, plays int, opts convertOptions) ([]byte, error) {
+	b := frames[0].Bounds()
+	var body bytes.Buffer
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10 // animation, alpha
+	putUint24(vp8x[4:7], b.Dx()-1)
+	putUint24(vp8x[7:10], b.Dy()-1)
+	writeChunk(&body, "VP8X", vp8x)
+
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:6], uint16(plays))
+	writeChunk(&body, "ANIM", anim)
+
+	for i, frame := range frames {
+		var still bytes.Buffer
+		if err := webp.Encode(&still, frame, &webp.Options{Lossless: opts.lossless, Quality: opts.quality}); err != nil {
+			return nil, encodeError(fmt.Sprintf("frame %d", i), err)
+		}
+		chunks, err := readWebPChunks(still.Bytes())
+		if err != nil {
+			return nil, encodeError(fmt.Sprintf("frame %d", i), err)
+		}
+
+		var anmf bytes.Buffer
+		hdr := make([]byte, 16)
+		putUint24(hdr[6:9], b.Dx()-1)
+		putUint24(hdr[9:12], b.Dy()-1)
+		putUint24(hdr[12:15], min(delays[i], 1<<24-1))
+		hdr[15] = 0x02 // do not blend; each frame is a full keyframe
+		anmf.Write(hdr)
+		for _, c := range chunks {
+			switch c.fourCC {
+			case "ALPH", "VP8 ", "VP8L":
+				writeChunk(&anmf, c.fourCC, c.payload)
+			}
+		}
+		writeChunk(&body, "ANMF", anmf.Bytes())
+	}
+
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(4+body.Len()))
+	file.WriteString("WEBP")
+	file.Write(body.Bytes())
+	return file.Bytes(), nil
+}
+
+// encodeAPNG writes frames as an 8-bit RGBA animated PNG
+func encodeAPNG(frames []*image.RGBA, delays []int, plays int) ([]byte, error) {
+	b := frames[0].Bounds()
+	var out bytes.Buffer
+	out.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(b.Dy()))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+	writePNGChunk(&out, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:8], uint32(plays))
+	writePNGChunk(&out, "acTL", actl)
+
+	seq := uint32(0)
+	for i, frame := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		seq++
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(b.Dy()))
+		binary.BigEndian.PutUint16(fctl[20:22], uint16(min(delays[i], 65535)))
+		binary.BigEndian.PutUint16(fctl[22:24], 1000)
+		// dispose_op and blend_op stay 0 (none, source)
+		writePNGChunk(&out, "fcTL", fctl)
+
+		data, err := deflateRGBA(frame)
+		if err != nil {
+			return nil, encodeError(fmt.Sprintf("frame %d", i), err)
+		}
+		if i == 0 {
+			writePNGChunk(&out, "IDAT", data)
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, seq)
+		seq++
+		writePNGChunk(&out, "fdAT", append(fdat, data...))
+	}
+	writePNGChunk(&out, "IEND", nil)
+	return out.Bytes(), nil
+}
+
+// deflateRGBA compresses img as unfiltered PNG scanlines. The pixels are
+// converted from premultiplied to straight alpha as PNG requires.
+func deflateRGBA(img *image.RGBA) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	bw := bufio.NewWriter(zw)
+	b := img.Bounds()
+	row := make([]byte, 1+4*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i := 1 + 4*(x-b.Min.X)
+			if c.A != 0 && c.A != 255 {
+				c.R = uint8(int(c.R) * 255 / int(c.A))
+				c.G = uint8(int(c.G) * 255 / int(c.A))
+				c.B = uint8(int(c.B) * 255 / int(c.A))
+			}
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+		if _, err := bw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePNGChunk appends a length-prefixed, CRC-terminated PNG chunk
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseAspect parses a ratio like "3:4" or "1.5" into width/height
+func parseAspect(s string) (float64, error) {
+	w, h, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		h = "1"
+	}
+	fw, err1 := strconv.ParseFloat(w, 64)
+	fh, err2 := strconv.ParseFloat(h, 64)
+	if err1 != nil || err2 != nil || fw <= 0 || fh <= 0 {
+		return 0, fmt.Errorf("invalid aspect ratio %q (use W:H, e.g. 3:4)", s)
+	}
+	return fw / fh, nil
+}
+
+// aspectMatches reports whether w x h is within tolerance (a fraction,
+// e.g. 0.01 for 1%) of the target width/height ratio
+func aspectMatches(w, h int, target, tolerance float64) bool {
+	if w <= 0 || h <= 0 {
+		return false
+	}
+	return math.Abs(float64(w)/float64(h)/target-1) <= tolerance
+}
+
+// fitAspect makes img conform to the target ratio, either by cropping the
+// centre ("crop") or by padding with transparency ("pad")
+func fitAspect(img image.Image, target float64, mode string) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var nw, nh int
+	switch mode {
+	case "crop":
+		nw, nh = w, h
+		if float64(w)/float64(h) > target {
+			nw = int(math.Round(float64(h) * target))
+		} else {
+			nh = int(math.Round(float64(w) / target))
+		}
+	case "pad":
+		nw, nh = w, h
+		if float64(w)/float64(h) > target {
+			nh = int(math.Round(float64(w) / target))
+		} else {
+			nw = int(math.Round(float64(h) * target))
+		}
+	default:
+		return img
+	}
+	nw, nh = max(nw, 1), max(nh, 1)
+	if nw == w && nh == h {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	if mode == "crop" {
+		src := image.Pt(b.Min.X+(w-nw)/2, b.Min.Y+(h-nh)/2)
+		draw.Draw(dst, dst.Bounds(), img, src, draw.Src)
+	} else {
+		off := image.Pt((nw-w)/2, (nh-h)/2)
+		draw.Draw(dst, image.Rectangle{Min: off, Max: off.Add(b.Size())}, img, b.Min, draw.Src)
+	}
+	return dst
+}
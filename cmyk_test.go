@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// cmykTIFF builds a little-endian CMYK TIFF header with one strip of count
+// bytes at offset, followed by pad bytes of pixel data
+func cmykTIFF(w, h, offset, count uint32, pad int) []byte {
+	type entry struct {
+		tag, typ uint16
+		val      uint32
+	}
+	entries := []entry{
+		{tiffImageWidth, 4, w},
+		{tiffImageLength, 4, h},
+		{tiffBitsPerSample, 3, 8},
+		{tiffCompression, 3, 1},
+		{tiffPhotometric, 3, tiffPhotometricCMYK},
+		{tiffStripOffsets, 4, offset},
+		{tiffSamplesPerPixel, 3, 4},
+		{tiffStripByteCounts, 4, count},
+	}
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e.tag)
+		binary.Write(&buf, binary.LittleEndian, e.typ)
+		binary.Write(&buf, binary.LittleEndian, uint32(1))
+		binary.Write(&buf, binary.LittleEndian, e.val)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.Write(make([]byte, pad))
+	return buf.Bytes()
+}
+
+func TestDecodeCMYKTIFFMalformed(t *testing.T) {
+	header := uint32(len(cmykTIFF(1, 1, 0, 0, 0)))
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"valid", cmykTIFF(2, 2, header, 16, 16), false},
+		{"huge size", cmykTIFF(1<<31-1, 1<<31-1, header, 16, 16), true},
+		{"size past file", cmykTIFF(100, 100, header, 16, 16), true},
+		{"strip past file", cmykTIFF(2, 2, header, 1<<31, 16), true},
+		{"strip offset past file", cmykTIFF(2, 2, 1<<31, 16, 16), true},
+	}
+	for _, tt := range tests {
+		_, _, err := decodeCMYKTIFF(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// conflictPolicies are the accepted --on-conflict values; ignore reports
+// sources sharing an output but leaves them to the usual existing-output
+// handling
+var conflictPolicies = []string{"ignore", "error", "skip", "suffix"}
+
+// outputRenames maps sources renamed by --on-conflict suffix to their
+// output path; set once before the pool starts
+var outputRenames map[string]string
+
+// plannedOutPath is makeOutPath after --on-conflict renaming
+func plannedOutPath(input string) string {
+	if p, ok := outputRenames[input]; ok {
+		return p
+	}
+	return makeOutPath(input)
+}
+
+// outputConflict is a source whose output path is already taken by another
+type outputConflict struct {
+	path    string
+	owner   string // source that keeps the output name
+	outPath string
+}
+
+// findOutputConflicts pre-computes every output path. The first source in
+// name order keeps a contested name; the others are returned as conflicts.
+func findOutputConflicts(files []string) []outputConflict {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	owners := make(map[string]string, len(sorted))
+	var conflicts []outputConflict
+	for _, p := range sorted {
+		out := makeOutPath(p)
+		if owner, ok := owners[out]; ok {
+			conflicts = append(conflicts, outputConflict{path: p, owner: owner, outPath: out})
+			continue
+		}
+		owners[out] = p
+	}
+	return conflicts
+}
+
+// resolveOutputConflicts applies the --on-conflict policy to files. It
+// returns the files to convert and those skipped because of a conflict.
+func resolveOutputConflicts(files []string, policy string) (keep []string, skipped []outputConflict, err error) {
+	conflicts := findOutputConflicts(files)
+	if len(conflicts) == 0 {
+		return files, nil, nil
+	}
+	if policy == "ignore" {
+		printConflictGroups(conflicts)
+		return files, nil, nil
+	}
+
+	switch policy {
+	case "error":
+		var lines []string
+		for _, c := range conflicts {
+			lines = append(lines, fmt.Sprintf("\t%s and %s both write %s", sourceName(c.owner), sourceName(c.path), c.outPath))
+		}
+		return nil, nil, fmt.Errorf("%d output name conflict(s) (use --on-conflict skip or suffix):\n%s", len(conflicts), strings.Join(lines, "\n"))
+	case "skip":
+		drop := make(map[string]struct{}, len(conflicts))
+		for _, c := range conflicts {
+			drop[c.path] = struct{}{}
+		}
+		for _, p := range files {
+			if _, ok := drop[p]; !ok {
+				keep = append(keep, p)
+			}
+		}
+		return keep, conflicts, nil
+	}
+
+	// suffix: name-1.webp, name-2.webp, ... avoiding every planned path
+	taken := make(map[string]struct{}, len(files))
+	for _, p := range files {
+		taken[makeOutPath(p)] = struct{}{}
+	}
+	outputRenames = make(map[string]string, len(conflicts))
+	for _, c := range conflicts {
+		stem := strings.TrimSuffix(c.outPath, ".webp")
+		for n := 1; ; n++ {
+			candidate := stem + "-" + strconv.Itoa(n) + ".webp"
+			if _, ok := taken[candidate]; !ok {
+				taken[candidate] = struct{}{}
+				outputRenames[c.path] = candidate
+				break
+			}
+		}
+	}
+	return files, nil, nil
+}
+
+// printConflictGroups lists each contested output with every source that
+// maps to it, for --on-conflict ignore
+func printConflictGroups(conflicts []outputConflict) {
+	groups := map[string][]string{}
+	var outs []string
+	for _, c := range conflicts {
+		if _, ok := groups[c.outPath]; !ok {
+			outs = append(outs, c.outPath)
+			groups[c.outPath] = []string{sourceName(c.owner)}
+		}
+		groups[c.outPath] = append(groups[c.outPath], sourceName(c.path))
+	}
+	sort.Strings(outs)
+	for _, out := range outs {
+		fmt.Fprintf(runLog, "[CONFLICT]\t%s <- %s (the first written is kept unless --overwrite; see --on-conflict)\n", out, strings.Join(groups[out], ", "))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+// contentHashLen is how many hex digits of the SHA-256 name an output
+// written with --content-addressed
+const contentHashLen = 16
+
+// contentManifestName is the file, under the output root, mapping each
+// source to its content-addressed output
+const contentManifestName = "manifest.json"
+
+// contentAddressedPath renames outPath to the hash of data, keeping its
+// directory and extension
+func contentAddressedPath(outPath string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return filepath.Join(filepath.Dir(outPath), hex.EncodeToString(sum[:])[:contentHashLen]+filepath.Ext(outPath))
+}
+
+// contentManifest maps source paths relative to --directory to their
+// outputs
+type contentManifest map[string]contentEntry
+
+// contentEntry is one manifest.json value: the output path relative to the
+// output root (slash-separated), its size, and the source size before any
+// trim or resize
+type contentEntry struct {
+	Output         string   `json:"output"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	OriginalWidth  int      `json:"originalWidth"`
+	OriginalHeight int      `json:"originalHeight"`
+	Palette        []string `json:"palette,omitempty"` // --extract-palette
+}
+
+// add records the output of one successful conversion
+func (m contentManifest) add(source string, stats convertStats) {
+	rel, err := filepath.Rel(outputRoot(), stats.outPath)
+	if err != nil {
+		rel = stats.outPath
+	}
+	m[exportKey(source)] = contentEntry{
+		Output:         filepath.ToSlash(rel),
+		Width:          stats.outWidth,
+		Height:         stats.outHeight,
+		OriginalWidth:  stats.srcWidth,
+		OriginalHeight: stats.srcHeight,
+		Palette:        stats.palette,
+	}
+}
+
+// write replaces the manifest atomically; keys are sorted by encoding/json
+func (m contentManifest) write() (string, error) {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(outputRoot(), contentManifestName)
+	return dest, writeFileAtomic(dest, append(data, '\n'))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// copyOtherFiles copies every file that won't be converted into the mirrored
+// --output-dir tree, so the output is a complete drop-in replacement.
+// Files whose destination is the output of one of sources are left out;
+// extra lists images that won't be converted and should be copied too.
+func copyOtherFiles(root string, recursive bool, skip map[string]struct{}, sources, extra []string) error {
+	reserved := make(map[string]struct{}, len(sources))
+	for _, src := range sources {
+		reserved[plannedOutPath(src)] = struct{}{}
+	}
+
+	files, err := walkFiles(root, recursive, func(name string) bool {
+		if _, ok := skip[strings.ToLower(filepath.Ext(name))]; ok {
+			return true
+		}
+		return !isImageExt(name)
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting files to copy: %w", err)
+	}
+	files = append(files, extra...)
+	for _, p := range files {
+		dest := filepath.Join(mirrorDir(filepath.Dir(p)), filepath.Base(p))
+		if _, ok := reserved[dest]; ok {
+			continue
+		}
+		if !opts.overwrite {
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+		}
+		if err := copyFile(p, dest); err != nil {
+			return fmt.Errorf("copy %s: %w", p, err)
+		}
+		fmt.Fprintf(runLog, "[COPY]\t%s\n", p)
+	}
+	return nil
+}
+
+// copyFile copies src to dest verbatim, keeping its mode and mtime
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// OpenFile applies the umask, so set the mode explicitly
+	if err := os.Chmod(tmp, st.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, st.ModTime(), st.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
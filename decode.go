@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// decodedSource is a source file after decoding and the per-format fixups
+// that depend only on the source (GIF matte, EXIF orientation)
+type decodedSource struct {
+	img   image.Image
+	anim  *gifAnimation // all frames when --anim-format keeps a GIF animated
+	exif  []byte        // cleaned EXIF from --normalize-exif
+	stats convertStats  // format, source bytes and dimensions
+}
+
+// sourceReader is what decoding needs from a source: a stream for the
+// decoder, rewinding to read metadata again and random access for CMYK
+// TIFF strips. *os.File and *bytes.Reader both provide it.
+type sourceReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// decodeSource opens and decodes inputPath exactly once
+func decodeSource(inputPath string, opts convertOptions) (decodedSource, error) {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return decodedSource{}, openError(err)
+	}
+	defer in.Close()
+	var size int64
+	if st, err := in.Stat(); err == nil {
+		size = st.Size()
+	}
+	return decodeSourceFrom(inputPath, in, size, opts)
+}
+
+// decodeSourceFrom decodes the size bytes of in, a source named name (its
+// extension picks the GIF and TIFF paths). Animated GIFs are decoded with
+// gif.DecodeAll so the first frame and the animation share one decode.
+func decodeSourceFrom(name string, in sourceReader, size int64, opts convertOptions) (decodedSource, error) {
+	var src decodedSource
+	src.stats.srcBytes = size
+
+	// Check the aspect ratio from the header before decoding the whole image
+	if opts.aspect > 0 && opts.autoFix == "" {
+		cfg, _, err := image.DecodeConfig(in)
+		if err != nil {
+			return src, decodeError(err)
+		}
+		if !aspectMatches(cfg.Width, cfg.Height, opts.aspect, opts.aspectTolerance) {
+			return src, fmt.Errorf("%w: %dx%d is not %s", errAspect, cfg.Width, cfg.Height, opts.requireAspect)
+		}
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return src, openError(err)
+		}
+	}
+
+	decodeCounts.add(name)
+	decoded, err := decodeWithTimeout(opts.decodeTimeout, func() (decodeResult, error) {
+		var res decodeResult
+		var err error
+		if opts.animFormat != "" && strings.EqualFold(filepath.Ext(name), ".gif") {
+			g, err := gif.DecodeAll(in)
+			if err != nil {
+				return res, decodeError(err)
+			}
+			res.img, res.format = g.Image[0], "gif"
+			if len(g.Image) > 1 {
+				res.anim = composeGIF(g)
+				res.img = res.anim.frames[0]
+			}
+		} else if isTIFFPath(name) && isCMYKTIFF(in) {
+			// x/image/tiff can't decode CMYK; without --cmyk it would fail vaguely
+			if !opts.cmyk {
+				return res, fmt.Errorf("%w: CMYK TIFF (use --cmyk to convert it through its ICC profile)", errUnsupportedFormat)
+			}
+			res.img, res.colorNote, err = decodeCMYKTIFF(in, src.stats.srcBytes)
+			if err != nil {
+				return res, decodeError(err)
+			}
+			res.format = "tiff"
+		} else {
+			res.img, res.format, err = image.Decode(in)
+			if err != nil {
+				return res, decodeError(err)
+			}
+		}
+		return res, nil
+	})
+	if err != nil {
+		return src, err
+	}
+	src.img, src.anim = decoded.img, decoded.anim
+	src.stats.srcAlpha = hasAlphaChannel(decoded.img)
+	src.stats.format, src.stats.colorNote = decoded.format, decoded.colorNote
+
+	// Convert embedded-profile colours (e.g. Display P3) to sRGB
+	if opts.forceSRGB {
+		if _, err := in.Seek(0, io.SeekStart); err == nil {
+			if profile, _ := readICCProfile(in, src.stats.format); profile != nil {
+				t, err := parseICCTransform(profile)
+				if err != nil {
+					src.stats.colorNote = fmt.Sprintf("colours left as-is: %v", err)
+				} else {
+					src.img = t.apply(src.img)
+					src.stats.colorNote = "converted to sRGB"
+				}
+			}
+		}
+	}
+
+	// GIF transparency is 1-bit; fade its edges into --matte
+	if opts.matte != "" && src.stats.format == "gif" {
+		src.img = applyMatte(src.img, opts.matteColor)
+	}
+
+	// Bake the EXIF orientation into the pixels and keep a cleaned EXIF block
+	if opts.normalizeExif && src.stats.format == "jpeg" {
+		if _, err := in.Seek(0, io.SeekStart); err == nil {
+			raw, _ := readJPEGExif(in)
+			if raw != nil {
+				if info, err := parseExif(raw); err == nil {
+					src.img = applyOrientation(src.img, info.orientation)
+					src.exif = buildExif(info)
+				}
+			}
+		}
+	}
+	src.stats.srcWidth, src.stats.srcHeight = src.img.Bounds().Dx(), src.img.Bounds().Dy()
+	return src, nil
+}
+
+// decodeResult is what the decoder itself produces, kept apart from
+// decodedSource so a timed-out decode can't write into the result
+type decodeResult struct {
+	img       image.Image
+	anim      *gifAnimation
+	format    string
+	colorNote string
+}
+
+// decodeWithTimeout runs decode, giving up after d (0 = no limit). A
+// decoder can't be interrupted, so on timeout it is left to finish in the
+// background; closing the source usually makes it fail fast.
+func decodeWithTimeout(d time.Duration, decode func() (decodeResult, error)) (decodeResult, error) {
+	if d <= 0 {
+		return decode()
+	}
+	type outcome struct {
+		res decodeResult
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := decode()
+		done <- outcome{res, err}
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.res, o.err
+	case <-timer.C:
+		return decodeResult{}, fmt.Errorf("%w: no result after %s", errDecodeTimeout, d)
+	}
+}
+
+// isTIFFPath reports whether p has a .tif or .tiff extension
+func isTIFFPath(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// decodedCache holds sources decoded by the --previews-first pass so the
+// full pass doesn't decode them again. A nil cache stores nothing.
+type decodedCache struct {
+	mu sync.Mutex
+	m  map[string]decodedSource
+}
+
+// sourceCache is set by --cache-decoded
+var sourceCache *decodedCache
+
+// put stores src for the full pass; only the preview pass stores
+func (c *decodedCache) put(path string, src decodedSource, preview bool) {
+	if c == nil || !preview {
+		return
+	}
+	c.mu.Lock()
+	c.m[path] = src
+	c.mu.Unlock()
+}
+
+// take returns and forgets a source cached by the preview pass
+func (c *decodedCache) take(path string, preview bool) (decodedSource, bool) {
+	if c == nil || preview {
+		return decodedSource{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src, ok := c.m[path]
+	delete(c.m, path)
+	return src, ok
+}
+
+// decodeCounter counts full decodes per source for --verify-single-decode,
+// including the --previews-first pass and the thumbnail pass over existing
+// .webp files. A nil counter counts nothing.
+type decodeCounter struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+// decodeCounts is set by --verify-single-decode
+var decodeCounts *decodeCounter
+
+func (c *decodeCounter) add(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.n[path]++
+	c.mu.Unlock()
+}
+
+// check returns an error naming every source decoded more than once
+func (c *decodeCounter) check() error {
+	if c == nil {
+		return nil
+	}
+	var multi []string
+	for p, n := range c.n {
+		if n > 1 {
+			multi = append(multi, fmt.Sprintf("%s (%d)", p, n))
+		}
+	}
+	if len(multi) == 0 {
+		return nil
+	}
+	sort.Strings(multi)
+	return fmt.Errorf("decoded more than once: %s", strings.Join(multi, ", "))
+}
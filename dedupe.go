@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// duplicateGroup is a set of files with identical content
+type duplicateGroup struct {
+	hash      string   // hex SHA-256 of the file content
+	canonical string   // deterministic representative, see canonicalLess
+	paths     []string // all members, canonical first
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalLess orders paths so the canonical copy comes first:
+// shortest path wins, ties are broken lexicographically
+func canonicalLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// findDuplicates groups paths by content hash. Only groups with more than
+// one member are returned, sorted by canonical path so re-runs are stable.
+func findDuplicates(paths []string) ([]duplicateGroup, error) {
+	byHash := map[string][]string{}
+	for _, p := range paths {
+		sum, err := hashFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", p, err)
+		}
+		byHash[sum] = append(byHash[sum], p)
+	}
+
+	var groups []duplicateGroup
+	for sum, members := range byHash {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool { return canonicalLess(members[i], members[j]) })
+		groups = append(groups, duplicateGroup{hash: sum, canonical: members[0], paths: members})
+	}
+	sort.Slice(groups, func(i, j int) bool { return canonicalLess(groups[i].canonical, groups[j].canonical) })
+	return groups, nil
+}
+
+// printDuplicates writes duplicate groups with the canonical copy marked by '*'
+func printDuplicates(groups []duplicateGroup) {
+	fmt.Fprintf(runLog, "Duplicates: %d group(s)\n", len(groups))
+	for _, g := range groups {
+		fmt.Fprintf(runLog, "[DUP]\t%s\n", g.hash[:12])
+		for _, p := range g.paths {
+			mark := " "
+			if p == g.canonical {
+				mark = "*"
+			}
+			fmt.Fprintf(runLog, "\t%s %s\n", mark, p)
+		}
+	}
+}
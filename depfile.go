@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// depfile collects Makefile-style dependency rules for --depfile, one per
+// converted source: its outputs depend on the source and any config file
+type depfile struct {
+	rules   []string
+	configs []string
+}
+
+func newDepfile() *depfile {
+	d := &depfile{}
+	if opts.recipe != "" {
+		d.configs = append(d.configs, opts.recipe)
+	}
+	return d
+}
+
+// add records the rule for one successful conversion, or for a source
+// skipped because its output already exists, so a rerun keeps the rule
+func (d *depfile) add(source string, stats convertStats) {
+	targets := []string{stats.outPath}
+	if len(opts.qualities) > 0 {
+		targets = targets[:0]
+		for _, q := range opts.qualities {
+			targets = append(targets, qualityVariantPath(stats.outPath, q))
+		}
+	}
+
+	deps := append([]string{source}, d.configs...)
+	d.rules = append(d.rules, escapeMake(targets)+": "+escapeMake(deps))
+}
+
+// write replaces path with the rules sorted by target
+func (d *depfile) write(path string) error {
+	sort.Strings(d.rules)
+	var b strings.Builder
+	for _, r := range d.rules {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+	if err := writeFileAtomic(path, []byte(b.String())); err != nil {
+		return fmt.Errorf("depfile: %w", err)
+	}
+	return nil
+}
+
+// makeEscaper quotes the characters make and ninja treat specially in paths
+var makeEscaper = strings.NewReplacer(" ", `\ `, "#", `\#`, "$", "$$")
+
+// escapeMake joins paths with spaces, escaping each one
+func escapeMake(paths []string) string {
+	escaped := make([]string, len(paths))
+	for i, p := range paths {
+		escaped[i] = makeEscaper.Replace(p)
+	}
+	return strings.Join(escaped, " ")
+}
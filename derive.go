@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+)
+
+// deriveSpec describes the responsive image set written by --derive
+type deriveSpec struct {
+	widths      []int
+	fallback    string // "" or "jpeg"
+	placeholder int    // placeholder width in pixels, 0 = none
+}
+
+// parseDeriveSpec parses "widths=320,640,1280;fallback=jpeg;placeholder=16"
+func parseDeriveSpec(s string) (*deriveSpec, error) {
+	spec := &deriveSpec{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("derive: %q is not key=value", part)
+		}
+		switch strings.TrimSpace(key) {
+		case "widths":
+			for _, w := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(w))
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("derive: invalid width %q", w)
+				}
+				spec.widths = append(spec.widths, n)
+			}
+		case "fallback":
+			switch val {
+			case "", "none":
+			case "jpeg", "jpg":
+				spec.fallback = "jpeg"
+			default:
+				return nil, fmt.Errorf("derive: fallback must be jpeg or none")
+			}
+		case "placeholder":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("derive: invalid placeholder width %q", val)
+			}
+			spec.placeholder = n
+		default:
+			return nil, fmt.Errorf("derive: unknown key %q (want widths, fallback or placeholder)", key)
+		}
+	}
+	if len(spec.widths) == 0 {
+		return nil, fmt.Errorf("derive: widths is required")
+	}
+	sort.Ints(spec.widths)
+	return spec, nil
+}
+
+// derivedFile is one entry of a name.set.json manifest
+type derivedFile struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int    `json:"bytes"`
+}
+
+// derivedSet is the name.set.json manifest written next to each output
+type derivedSet struct {
+	Width       int           `json:"width"`
+	Height      int           `json:"height"`
+	Variants    []derivedFile `json:"variants"`
+	Placeholder *derivedFile  `json:"placeholder,omitempty"`
+}
+
+// writeDerivedSet writes the --derive widths, fallbacks and placeholder for
+// img next to outPath, plus a name.set.json describing them. Widths at or
+// above the image width are skipped rather than upscaled.
+func writeDerivedSet(img image.Image, outPath string, opts convertOptions) error {
+	spec := opts.deriveSpec
+	base := strings.TrimSuffix(outPath, ".webp")
+	b := img.Bounds()
+	set := derivedSet{Width: b.Dx(), Height: b.Dy(), Variants: []derivedFile{}}
+	// JPEG fallbacks follow the WebP quality unless --jpeg-quality says otherwise
+	jpegQuality := opts.quality
+	if opts.jpegQuality > 0 {
+		jpegQuality = float32(opts.jpegQuality)
+	}
+
+	write := func(name, format string, dst image.Image, quality float32) (derivedFile, error) {
+		var buf bytes.Buffer
+		var err error
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, flatten(dst, color.White), &jpeg.Options{Quality: int(quality)})
+		} else {
+			err = webp.Encode(&buf, dst, &webp.Options{Lossless: opts.lossless, Quality: quality})
+		}
+		if err != nil {
+			return derivedFile{}, encodeError("derived "+format, err)
+		}
+		if err := writeFileAtomic(name, buf.Bytes()); err != nil {
+			return derivedFile{}, writeError(err)
+		}
+		return derivedFile{
+			File:   filepath.Base(name),
+			Format: format,
+			Width:  dst.Bounds().Dx(),
+			Height: dst.Bounds().Dy(),
+			Bytes:  buf.Len(),
+		}, nil
+	}
+
+	for _, w := range spec.widths {
+		if w >= b.Dx() {
+			continue
+		}
+		dst := scaledToWidth(img, w, opts.progressiveDownscale)
+		f, err := write(fmt.Sprintf("%s-%dw.webp", base, w), "webp", dst, opts.quality)
+		if err != nil {
+			return err
+		}
+		set.Variants = append(set.Variants, f)
+		if spec.fallback == "jpeg" {
+			f, err := write(fmt.Sprintf("%s-%dw.jpg", base, w), "jpeg", dst, jpegQuality)
+			if err != nil {
+				return err
+			}
+			set.Variants = append(set.Variants, f)
+		}
+	}
+
+	if spec.placeholder > 0 && spec.placeholder < b.Dx() {
+		dst := boxBlur(scaledToWidth(img, spec.placeholder, true))
+		f, err := write(base+"-placeholder.webp", "webp", dst, 30)
+		if err != nil {
+			return err
+		}
+		set.Placeholder = &f
+	}
+
+	data, err := json.MarshalIndent(set, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+".set.json", append(data, '\n')); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// scaledToWidth resizes img to width w, keeping its aspect ratio
+func scaledToWidth(img image.Image, w int, progressive bool) *image.RGBA {
+	b := img.Bounds()
+	h := int(math.Round(float64(b.Dy()) * float64(w) / float64(b.Dx())))
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	scaleInto(dst, img, progressive)
+	return dst
+}
+
+// boxBlur applies a 3x3 box blur, clamping at the edges
+func boxBlur(src *image.RGBA) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var sum [4]int
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := image.Point{x + dx, y + dy}
+					if !p.In(b) {
+						continue
+					}
+					c := src.RGBAAt(p.X, p.Y)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+	return dst
+}
+
+// flatten composites img over bg, since JPEG has no alpha
+func flatten(img image.Image, bg color.Color) image.Image {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+	"github.com/spf13/cobra"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check which formats this build can decode and encode",
+	Long: `Round-trips a tiny test image through every supported format and reports
+which inputs can be decoded and which outputs can be encoded in this build,
+along with the Go and library versions.`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// doctorFormat is one format checked by doctor. encode writes the test
+// image in that format; decode reports whether image.Decode reads it back.
+type doctorFormat struct {
+	name   string
+	encode func(w io.Writer, img image.Image) error
+	decode bool // an input format for conversion
+	output bool // an output format of the tool
+}
+
+var doctorFormats = []doctorFormat{
+	{"jpeg", func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }, true, true},
+	{"png", png.Encode, true, false},
+	{"gif", func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) }, true, false},
+	{"bmp", bmp.Encode, true, false},
+	{"tiff", func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) }, true, false},
+	{"webp", func(w io.Writer, img image.Image) error {
+		return webp.Encode(w, img, &webp.Options{Quality: 80})
+	}, true, true},
+	{"apng", func(w io.Writer, img image.Image) error {
+		frame := image.NewRGBA(img.Bounds())
+		for y := 0; y < frame.Bounds().Dy(); y++ {
+			for x := 0; x < frame.Bounds().Dx(); x++ {
+				frame.Set(x, y, img.At(x, y))
+			}
+		}
+		data, err := encodeAPNG([]*image.RGBA{frame, frame}, []int{100, 100}, 0)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}, false, true},
+}
+
+// runDoctor prints one [OK]/[FAIL] line per check and fails if any check
+// that this build should pass does not
+func runDoctor(cmd *cobra.Command, args []string) error {
+	fmt.Printf("image-convert %s, %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if strings.HasPrefix(dep.Path, "github.com/chai2010/webp") || strings.HasPrefix(dep.Path, "golang.org/x/image") {
+				fmt.Printf("  %s %s\n", dep.Path, dep.Version)
+			}
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 32), uint8(y * 32), 128, 255})
+		}
+	}
+
+	failed := 0
+	for _, f := range doctorFormats {
+		var buf bytes.Buffer
+		encErr := f.encode(&buf, img)
+		if f.output {
+			doctorReport(&failed, "encode "+f.name, encErr)
+		}
+		if !f.decode {
+			continue
+		}
+		if encErr != nil {
+			doctorReport(&failed, "decode "+f.name, fmt.Errorf("no test image: %w", encErr))
+			continue
+		}
+		decoded, format, err := image.Decode(&buf)
+		if err == nil && format != f.name {
+			err = fmt.Errorf("decoded as %s", format)
+		}
+		if err == nil && decoded.Bounds().Dx() != 8 {
+			err = fmt.Errorf("decoded size %v", decoded.Bounds().Size())
+		}
+		doctorReport(&failed, "decode "+f.name, err)
+	}
+
+	// Formats people ask about that need decoders this build doesn't link
+	for _, name := range []string{"heic", "avif"} {
+		fmt.Printf("[SKIP]\tdecode %s (no decoder in this build)\n", name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+// doctorReport prints the result of one doctor check
+func doctorReport(failed *int, check string, err error) {
+	if err != nil {
+		*failed++
+		fmt.Printf("[FAIL]\t%s: %v\n", check, err)
+		return
+	}
+	fmt.Printf("[OK]\t%s\n", check)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseDPRs parses "1,2,3" into distinct ascending pixel densities
+func parseDPRs(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(part)), "x")
+		if part == "" {
+			continue
+		}
+		d, err := strconv.Atoi(part)
+		if err != nil || d < 1 || d > 4 {
+			return nil, fmt.Errorf("invalid density %q in --dpr (use 1-4)", part)
+		}
+		if !slices.Contains(out, d) {
+			out = append(out, d)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("dpr: no densities given")
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// dprVariantPath turns name.webp into name@2x.webp; 1x keeps the plain name
+func dprVariantPath(outPath string, dpr int) string {
+	if dpr == 1 {
+		return outPath
+	}
+	return strings.TrimSuffix(outPath, ".webp") + "@" + strconv.Itoa(dpr) + "x.webp"
+}
+
+// dprVariantPattern matches names written by --dpr
+var dprVariantPattern = regexp.MustCompile(`(?i)@(\d)x\.webp$`)
+
+// variantDPR returns the density encoded in a --dpr output name, or 0
+func variantDPR(name string) int {
+	m := dprVariantPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0
+	}
+	d, _ := strconv.Atoi(m[1])
+	return d
+}
+
+// writeDPRSet writes one output per --dpr density from a single decode,
+// each limited to --width times its density. Thumbnails, trim bounds and
+// the --derive set are written once, for the lowest density, and stats
+// describe that output. Densities the source is too narrow for are still
+// written at the source's size and listed in stats.dprCapped.
+func writeDPRSet(img image.Image, outPath string, opts convertOptions, stats *convertStats) error {
+	srcW := img.Bounds().Dx()
+	var first convertStats
+	var outBytes int64
+	written := 0
+	for i, d := range opts.dprs {
+		variant := opts
+		variant.maxWidth = opts.maxWidth * d
+		if opts.maxHeight > 0 {
+			variant.maxHeight = opts.maxHeight * d
+		}
+		if i > 0 {
+			variant.thumbnailPercent = 0
+			variant.emitTrimBounds = false
+			variant.deriveSpec = nil
+		}
+		if srcW < variant.maxWidth {
+			stats.dprCapped = append(stats.dprCapped, d)
+		}
+		err := writeWebp(img, dprVariantPath(outPath, d), variant, stats)
+		if errors.Is(err, errSkipped) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			first = *stats
+		}
+		written++
+		outBytes += stats.outBytes
+	}
+	// stats.skipReason holds the last variant's reason
+	if written == 0 {
+		return errSkipped
+	}
+	stats.outPath, stats.outWidth, stats.outHeight = first.outPath, first.outWidth, first.outHeight
+	stats.outBytes = outBytes
+	return nil
+}
+
+// dprNote describes the densities a source was too small for
+func dprNote(capped []int, srcW int) string {
+	names := make([]string, len(capped))
+	for i, d := range capped {
+		names[i] = strconv.Itoa(d) + "x"
+	}
+	return fmt.Sprintf("source %dpx wide, too small for %s", srcW, strings.Join(names, ", "))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var dupesCmd = &cobra.Command{
+	Use:   "dupes [DIRECTORY]",
+	Short: "Report duplicate source images without converting",
+	Long: `Hashes every image under DIRECTORY (default: current directory) and
+reports groups of byte-identical files, marking the canonical copy that a
+clean-up would keep. With --similar, images whose perceptual hashes differ
+in at most that many bits are also grouped as near-duplicates. Nothing is
+converted or deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDupes,
+}
+
+// dupesOpts holds the dupes subcommand flags
+var dupesOpts struct {
+	recursive bool
+	similar   int
+	json      bool
+}
+
+func init() {
+	dupesCmd.Flags().BoolVarP(&dupesOpts.recursive, "recursive", "r", false, "Recurse into subdirectories")
+	dupesCmd.Flags().IntVar(&dupesOpts.similar, "similar", -1, "Also group images whose perceptual hashes differ in at most this many of 64 bits (-1 = identical files only; 0-10 is typical)")
+	dupesCmd.Flags().BoolVar(&dupesOpts.json, "json", false, "Print the groups as JSON instead of a table")
+	rootCmd.AddCommand(dupesCmd)
+}
+
+// similarGroup is a set of images within --similar bits of each other's
+// perceptual hash, joined transitively
+type similarGroup struct {
+	Distance int      `json:"distance"` // largest distance between linked members
+	Paths    []string `json:"paths"`
+}
+
+// dupesReport is the --json output of the dupes subcommand
+type dupesReport struct {
+	Identical []identicalGroup `json:"identical"`
+	Similar   []similarGroup   `json:"similar,omitempty"`
+}
+
+// identicalGroup is duplicateGroup as JSON
+type identicalGroup struct {
+	Hash      string   `json:"hash"`
+	Canonical string   `json:"canonical"`
+	Paths     []string `json:"paths"`
+}
+
+func runDupes(cmd *cobra.Command, args []string) error {
+	if dupesOpts.similar > 64 {
+		return fmt.Errorf("similar must be at most 64")
+	}
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	files, err := collectImageFiles(dir, dupesOpts.recursive, nil)
+	if err != nil {
+		return fmt.Errorf("error collecting files: %w", err)
+	}
+	groups, err := findDuplicates(files)
+	if err != nil {
+		return err
+	}
+
+	var similar []similarGroup
+	if dupesOpts.similar >= 0 {
+		// Identical copies would only repeat their canonical's match
+		skip := map[string]bool{}
+		for _, g := range groups {
+			for _, p := range g.paths[1:] {
+				skip[p] = true
+			}
+		}
+		var unique []string
+		for _, p := range files {
+			if !skip[p] {
+				unique = append(unique, p)
+			}
+		}
+		similar = findSimilar(unique, dupesOpts.similar)
+	}
+
+	if dupesOpts.json {
+		report := dupesReport{Identical: []identicalGroup{}, Similar: similar}
+		for _, g := range groups {
+			report.Identical = append(report.Identical, identicalGroup{Hash: g.hash, Canonical: g.canonical, Paths: g.paths})
+		}
+		data, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printDuplicates(groups)
+	if dupesOpts.similar >= 0 {
+		fmt.Printf("Similar: %d group(s) within %d bits\n", len(similar), dupesOpts.similar)
+		for _, g := range similar {
+			fmt.Printf("[SIMILAR]\tdistance %d\n", g.Distance)
+			for _, p := range g.Paths {
+				fmt.Printf("\t  %s\n", p)
+			}
+		}
+	}
+	return nil
+}
+
+// findSimilar groups paths whose perceptual hashes are within maxDistance
+// bits, linking transitively. Files that fail to decode are reported and
+// left out. Groups are sorted by their first path.
+func findSimilar(paths []string, maxDistance int) []similarGroup {
+	type hashed struct {
+		path string
+		hash uint64
+	}
+	var items []hashed
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: decode %s: %v\n", p, err)
+			continue
+		}
+		items = append(items, hashed{path: p, hash: perceptualHash(img)})
+	}
+
+	// Union-find over every pair within range
+	parent := make([]int, len(items))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	linkDist := map[int]int{}
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			d := bits.OnesCount64(items[i].hash ^ items[j].hash)
+			if d > maxDistance {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			dist := max(d, linkDist[ri], linkDist[rj])
+			if ri != rj {
+				parent[rj] = ri
+			}
+			linkDist[ri] = dist
+		}
+	}
+
+	members := map[int][]string{}
+	for i, it := range items {
+		r := find(i)
+		members[r] = append(members[r], it.path)
+	}
+	var groups []similarGroup
+	for r, ps := range members {
+		if len(ps) < 2 {
+			continue
+		}
+		sort.Slice(ps, func(i, j int) bool { return canonicalLess(ps[i], ps[j]) })
+		groups = append(groups, similarGroup{Distance: linkDist[r], Paths: ps})
+	}
+	sort.Slice(groups, func(i, j int) bool { return canonicalLess(groups[i].Paths[0], groups[j].Paths[0]) })
+	return groups
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"syscall"
+)
+
+// Failure categories returned (wrapped) from convertOne. Use errorCode to get
+// a stable identifier for reporting.
+var (
+	errOpen              = errors.New("open")
+	errUnsupportedFormat = errors.New("unsupported format")
+	errTruncated         = errors.New("truncated")
+	errDecode            = errors.New("decode")
+	errDecodeTimeout     = errors.New("decode timeout")
+	errEncode            = errors.New("encode")
+	errDiskFull          = errors.New("disk full")
+	errWrite             = errors.New("write")
+	errAspect            = errors.New("aspect mismatch")
+	errBlank             = errors.New("blank image")
+	errRoundtrip         = errors.New("roundtrip mismatch")
+)
+
+// errorCodes maps each category to its stable code, in match order
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errSkipped, "skipped"},
+	{errOpen, "open"},
+	{errUnsupportedFormat, "unsupported_format"},
+	{errTruncated, "truncated"},
+	{errDecodeTimeout, "decode_timeout"},
+	{errDecode, "decode"},
+	{errEncode, "encode"},
+	{errDiskFull, "disk_full"},
+	{errWrite, "write"},
+	{errAspect, "aspect_mismatch"},
+	{errBlank, "blank_image"},
+	{errRoundtrip, "roundtrip_mismatch"},
+}
+
+// errorCode returns the stable code for err's category, or "unknown"
+func errorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "unknown"
+}
+
+// openError tags a failure to open a source
+func openError(err error) error {
+	return fmt.Errorf("%w: %w", errOpen, err)
+}
+
+// decodeError tags a decode failure as unsupported, truncated or generic
+func decodeError(err error) error {
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		return fmt.Errorf("%w: %w", errUnsupportedFormat, err)
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: %w", errTruncated, err)
+	}
+	return fmt.Errorf("%w: %w", errDecode, err)
+}
+
+// encodeError tags an encoder failure. Encoders that stream to a file can
+// fail on a full disk, which is reported as such.
+func encodeError(what string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %s: %w", errDiskFull, what, err)
+	}
+	return fmt.Errorf("%w %s: %w", errEncode, what, err)
+}
+
+// writeError tags a filesystem write failure, separating out a full disk
+func writeError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", errDiskFull, err)
+	}
+	return fmt.Errorf("%w: %w", errWrite, err)
+}
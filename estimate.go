@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// runEstimate encodes every file in memory with the current settings and
+// reports the bytes the sources take now against what their WebPs would
+// take. Nothing is written, skipped for an existing output or deleted;
+// the error reports how many files failed.
+func runEstimate(files []string) error {
+	if len(files) == 0 {
+		fmt.Fprintln(runLog, "No images found to estimate.")
+		return nil
+	}
+	estimateOpts := opts
+	estimateOpts.noWrite = true
+	estimateOpts.overwrite = true
+	estimateOpts.deleteOriginal = false
+	estimateOpts.thumbnailPercent = 0
+	estimateOpts.deriveSpec = nil
+
+	fmt.Fprintf(runLog, "Found %d image(s). Estimating...\n", len(files))
+	pool := newConverterPool(opts.workers, estimateOpts)
+	go func() {
+		for _, f := range files {
+			pool.Enqueue(f)
+		}
+		pool.Close()
+	}()
+
+	var totals byteTotals
+	failed, skipped := 0, 0
+	for r := range pool.Results() {
+		switch {
+		case errors.Is(r.err, errSkipped):
+			skipped++
+			printSkip(r.path, r.stats.skipReason)
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "[FAIL]\t%s [%s]: %v\n", r.path, errorCode(r.err), r.err)
+		default:
+			totals.add(r.stats)
+			fmt.Fprintf(runLog, "[EST]\t%s\t%d -> %d bytes\n", r.path, r.stats.srcBytes, r.stats.outBytes)
+		}
+	}
+
+	src, out := totals.srcBytes.Load(), totals.outBytes.Load()
+	saved := 0.0
+	if src > 0 {
+		saved = 100 * float64(src-out) / float64(src)
+	}
+	fmt.Fprintf(runLog, "Estimate: %d file(s), %d bytes now, %d bytes as WebP, %d bytes (%.1f%%) saved\n", totals.files.Load(), src, out, src-out, saved)
+	if skipped > 0 || failed > 0 {
+		fmt.Fprintf(runLog, "Not counted: %d skipped, %d failed\n", skipped, failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EXIF tags kept by --normalize-exif
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// exifInfo is the subset of EXIF that survives normalization
+type exifInfo struct {
+	orientation      int
+	make             string
+	model            string
+	dateTime         string
+	dateTimeOriginal string
+}
+
+// readJPEGExif returns the TIFF-structured EXIF payload of a JPEG's APP1
+// segment, or nil if there is none
+func readJPEGExif(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errors.New("not a JPEG")
+	}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("bad JPEG marker")
+		}
+		// Start of scan or end of image: no more metadata segments
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, nil
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, errors.New("bad JPEG segment length")
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:], nil
+		}
+	}
+}
+
+// parseExif extracts orientation, camera and date fields from TIFF data
+func parseExif(tiff []byte) (exifInfo, error) {
+	info := exifInfo{orientation: 1}
+	if len(tiff) < 8 {
+		return info, errors.New("short EXIF data")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return info, errors.New("bad EXIF byte order")
+	}
+
+	readIFD := func(off uint32, visit func(tag, typ uint16, count uint32, value []byte)) {
+		if int(off)+2 > len(tiff) {
+			return
+		}
+		n := int(order.Uint16(tiff[off:]))
+		for i := 0; i < n; i++ {
+			e := int(off) + 2 + i*12
+			if e+12 > len(tiff) {
+				return
+			}
+			tag := order.Uint16(tiff[e:])
+			typ := order.Uint16(tiff[e+2:])
+			count := order.Uint32(tiff[e+4:])
+			value := tiff[e+8 : e+12]
+			// ASCII values longer than 4 bytes live at an offset
+			if typ == 2 && count > 4 {
+				vo := order.Uint32(value)
+				if uint64(vo)+uint64(count) > uint64(len(tiff)) {
+					continue
+				}
+				value = tiff[vo : vo+count]
+			}
+			visit(tag, typ, count, value)
+		}
+	}
+	ascii := func(v []byte, count uint32) string {
+		if int(count) < len(v) {
+			v = v[:count]
+		}
+		return strings.TrimRight(string(v), "\x00 ")
+	}
+
+	var exifOff uint32
+	readIFD(order.Uint32(tiff[4:]), func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagOrientation:
+			if typ == 3 {
+				info.orientation = int(order.Uint16(value))
+			}
+		case tagMake:
+			info.make = ascii(value, count)
+		case tagModel:
+			info.model = ascii(value, count)
+		case tagDateTime:
+			info.dateTime = ascii(value, count)
+		case tagExifIFD:
+			exifOff = order.Uint32(value)
+		}
+	})
+	if exifOff != 0 {
+		readIFD(exifOff, func(tag, typ uint16, count uint32, value []byte) {
+			if tag == tagDateTimeOriginal {
+				info.dateTimeOriginal = ascii(value, count)
+			}
+		})
+	}
+	if info.orientation < 1 || info.orientation > 8 {
+		info.orientation = 1
+	}
+	return info, nil
+}
+
+// buildExif writes a little-endian TIFF block holding only the camera and
+// date fields, with orientation reset to 1
+func buildExif(info exifInfo) []byte {
+	type entry struct {
+		tag   uint16
+		typ   uint16
+		count uint32
+		data  []byte // inline if <= 4 bytes
+	}
+	asciiEntry := func(tag uint16, s string) entry {
+		return entry{tag: tag, typ: 2, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+	}
+	short := func(tag uint16, v uint16) entry {
+		d := make([]byte, 4)
+		binary.LittleEndian.PutUint16(d, v)
+		return entry{tag: tag, typ: 3, count: 1, data: d}
+	}
+	long := func(tag uint16, v uint32) entry {
+		d := make([]byte, 4)
+		binary.LittleEndian.PutUint32(d, v)
+		return entry{tag: tag, typ: 4, count: 1, data: d}
+	}
+
+	ifd0 := []entry{short(tagOrientation, 1)}
+	if info.make != "" {
+		ifd0 = append(ifd0, asciiEntry(tagMake, info.make))
+	}
+	if info.model != "" {
+		ifd0 = append(ifd0, asciiEntry(tagModel, info.model))
+	}
+	if info.dateTime != "" {
+		ifd0 = append(ifd0, asciiEntry(tagDateTime, info.dateTime))
+	}
+	var sub []entry
+	if info.dateTimeOriginal != "" {
+		sub = append(sub, asciiEntry(tagDateTimeOriginal, info.dateTimeOriginal))
+		ifd0 = append(ifd0, long(tagExifIFD, 0)) // patched below
+	}
+	sort.Slice(ifd0, func(i, j int) bool { return ifd0[i].tag < ifd0[j].tag })
+
+	ifdSize := func(n int) int { return 2 + n*12 + 4 }
+	// Layout: header, IFD0, sub-IFD, then out-of-line values
+	ifd0Off := 8
+	subOff := ifd0Off + ifdSize(len(ifd0))
+	dataOff := subOff
+	if len(sub) > 0 {
+		dataOff += ifdSize(len(sub))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("II")
+	binary.Write(&out, binary.LittleEndian, uint16(42))
+	binary.Write(&out, binary.LittleEndian, uint32(ifd0Off))
+
+	var extra bytes.Buffer
+	writeIFD := func(entries []entry) {
+		binary.Write(&out, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&out, binary.LittleEndian, e.tag)
+			binary.Write(&out, binary.LittleEndian, e.typ)
+			binary.Write(&out, binary.LittleEndian, e.count)
+			switch {
+			case e.tag == tagExifIFD:
+				binary.Write(&out, binary.LittleEndian, uint32(subOff))
+			case len(e.data) <= 4:
+				v := make([]byte, 4)
+				copy(v, e.data)
+				out.Write(v)
+			default:
+				binary.Write(&out, binary.LittleEndian, uint32(dataOff+extra.Len()))
+				extra.Write(e.data)
+				if extra.Len()&1 == 1 {
+					extra.WriteByte(0)
+				}
+			}
+		}
+		binary.Write(&out, binary.LittleEndian, uint32(0)) // no next IFD
+	}
+	writeIFD(ifd0)
+	if len(sub) > 0 {
+		writeIFD(sub)
+	}
+	out.Write(extra.Bytes())
+	return out.Bytes()
+}
+
+// applyOrientation rotates/flips img so EXIF orientation o becomes 1
+func applyOrientation(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	swap := o >= 5
+	dw, dh := w, h
+	if swap {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 CCW
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadExport reads an existing info.json for --append-export, keyed by
+// exportKey, along with its modification time. A missing file yields no
+// entries. Both the flat and the --group-by dir layouts are accepted.
+func loadExport(dest string) (map[string]exportInfo, time.Time, error) {
+	st, err := os.Stat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	entries := map[string]exportInfo{}
+	var flat []exportInfo
+	if err := json.Unmarshal(data, &flat); err == nil {
+		for _, e := range flat {
+			// Entries written without --append-export carry only the base
+			// name, which is the key for files at the top level
+			key := e.Path
+			if key == "" {
+				key = e.Name
+			}
+			entries[key] = e
+		}
+		return entries, st.ModTime(), nil
+	}
+	var groups map[string][]exportInfo
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, time.Time{}, fmt.Errorf("read %s: %w", dest, err)
+	}
+	for dir, es := range groups {
+		for _, e := range es {
+			entries[path.Join(dir, e.Name)] = e
+		}
+	}
+	return entries, st.ModTime(), nil
+}
+
+// modifiedSince reports whether p or its thumbnail changed after t
+func modifiedSince(p string, t time.Time) bool {
+	st, err := os.Stat(p)
+	if err != nil || st.ModTime().After(t) {
+		return true
+	}
+	thumb, err := os.Stat(strings.TrimSuffix(p, ".webp") + "_thumbnail.webp")
+	return err == nil && thumb.ModTime().After(t)
+}
+
+// probeExports fills out[i] with probeExport(paths[i]) for every index in
+// todo, using up to workers goroutines. Each worker writes only its own
+// slots, so out keeps the sorted order. The error returned is the first in
+// path order, so runs fail the same way every time.
+func probeExports(paths []string, todo []int, out []exportInfo, workers int) error {
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(workers, 1), len(todo)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				out[i], errs[i] = probeExport(paths[i])
+			}
+		}()
+	}
+	for _, i := range todo {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, i := range todo {
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// failureReport collects failed conversions for the end-of-run summary
+// printed by --keep-going-report
+type failureReport struct {
+	byCode map[string][]convertResult
+}
+
+func newFailureReport() *failureReport {
+	return &failureReport{byCode: map[string][]convertResult{}}
+}
+
+// add records one failed result under its error code
+func (f *failureReport) add(r convertResult) {
+	code := errorCode(r.err)
+	f.byCode[code] = append(f.byCode[code], r)
+}
+
+// print writes every failure grouped by error code, codes and paths sorted
+func (f *failureReport) print(w io.Writer) {
+	if len(f.byCode) == 0 {
+		return
+	}
+	codes := make([]string, 0, len(f.byCode))
+	total := 0
+	for code, rs := range f.byCode {
+		codes = append(codes, code)
+		total += len(rs)
+	}
+	sort.Strings(codes)
+	fmt.Fprintf(w, "Failures: %d\n", total)
+	for _, code := range codes {
+		rs := f.byCode[code]
+		sort.Slice(rs, func(i, j int) bool { return rs[i].path < rs[j].path })
+		fmt.Fprintf(w, "[%s] %d\n", code, len(rs))
+		for _, r := range rs {
+			fmt.Fprintf(w, "\t%s: %v\n", r.path, r.err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+	"github.com/spf13/cobra"
+)
+
+var faviconCmd = &cobra.Command{
+	Use:   "favicon SOURCE",
+	Short: "Write a standard favicon set from one image",
+	Long: `Writes the conventional favicon sizes (16, 32, 48, 180, 192 and 512 px)
+from a single source as WebP, and optionally PNG for browsers and
+platforms that need it. Non-square sources are padded to a square with
+transparency. A site.webmanifest for the 192 and 512 px icons is written
+next to them, and the matching <link> tags are printed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runFavicon,
+}
+
+// faviconOpts holds the favicon subcommand flags
+var faviconOpts struct {
+	outputDir string
+	png       bool
+	lossless  bool
+	quality   float32
+}
+
+func init() {
+	faviconCmd.Flags().StringVar(&faviconOpts.outputDir, "output-dir", "", "Directory for the icons (default: next to the source)")
+	faviconCmd.Flags().BoolVar(&faviconOpts.png, "png", false, "Also write each size as PNG")
+	faviconCmd.Flags().BoolVar(&faviconOpts.lossless, "lossless", true, "Encode the WebP icons losslessly")
+	faviconCmd.Flags().Float32Var(&faviconOpts.quality, "quality", 90, "WebP quality with --lossless=false (0-100)")
+	rootCmd.AddCommand(faviconCmd)
+}
+
+// faviconSize is one icon of the set and the name it is conventionally
+// published under
+type faviconSize struct {
+	px   int
+	name string
+}
+
+var faviconSizes = []faviconSize{
+	{16, "favicon-16x16"},
+	{32, "favicon-32x32"},
+	{48, "favicon-48x48"},
+	{180, "apple-touch-icon"},
+	{192, "android-chrome-192x192"},
+	{512, "android-chrome-512x512"},
+}
+
+// webManifestIcon is one entry of the site.webmanifest "icons" list
+type webManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+func runFavicon(cmd *cobra.Command, args []string) error {
+	if faviconOpts.quality < 0 || faviconOpts.quality > 100 {
+		return fmt.Errorf("quality must be between 0 and 100")
+	}
+	source := args[0]
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", source, err)
+	}
+	img = fitAspect(img, 1, "pad")
+	if img.Bounds().Dx() < faviconSizes[len(faviconSizes)-1].px {
+		fmt.Fprintf(os.Stderr, "Warning: %s is %dpx wide, larger icons are upscaled\n", source, img.Bounds().Dx())
+	}
+
+	dir := faviconOpts.outputDir
+	if dir == "" {
+		dir = filepath.Dir(source)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	var icons []webManifestIcon
+	var links []string
+	for _, size := range faviconSizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size.px, size.px))
+		scaleInto(dst, img, true)
+
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, dst, &webp.Options{Lossless: faviconOpts.lossless, Quality: faviconOpts.quality}); err != nil {
+			return fmt.Errorf("encode %dpx webp: %w", size.px, err)
+		}
+		name := size.name + ".webp"
+		if err := writeFileAtomic(filepath.Join(dir, name), buf.Bytes()); err != nil {
+			return err
+		}
+		fmt.Printf("[OK]\t%s\n", filepath.Join(dir, name))
+		mime := "image/webp"
+
+		if faviconOpts.png {
+			buf.Reset()
+			if err := png.Encode(&buf, dst); err != nil {
+				return fmt.Errorf("encode %dpx png: %w", size.px, err)
+			}
+			pngName := size.name + ".png"
+			if err := writeFileAtomic(filepath.Join(dir, pngName), buf.Bytes()); err != nil {
+				return err
+			}
+			fmt.Printf("[OK]\t%s\n", filepath.Join(dir, pngName))
+			// Link the PNG when there is one; it works on every browser and iOS
+			name, mime = pngName, "image/png"
+		}
+
+		sizes := fmt.Sprintf("%dx%d", size.px, size.px)
+		switch size.px {
+		case 180:
+			links = append(links, fmt.Sprintf(`<link rel="apple-touch-icon" sizes="%s" href="/%s">`, sizes, name))
+		case 192, 512:
+			icons = append(icons, webManifestIcon{Src: "/" + name, Sizes: sizes, Type: mime})
+		default:
+			links = append(links, fmt.Sprintf(`<link rel="icon" type="%s" sizes="%s" href="/%s">`, mime, sizes, name))
+		}
+	}
+
+	manifest, err := json.MarshalIndent(map[string]any{"icons": icons}, "", "\t")
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(dir, "site.webmanifest")
+	if err := writeFileAtomic(manifestPath, append(manifest, '\n')); err != nil {
+		return err
+	}
+	fmt.Printf("[OK]\t%s\n", manifestPath)
+	links = append(links, `<link rel="manifest" href="/site.webmanifest">`)
+	fmt.Printf("\nAdd to <head>:\n%s\n", strings.Join(links, "\n"))
+	return nil
+}
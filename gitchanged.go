@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitOutput runs git with args in dir and returns its standard output
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// gitChangedPaths returns the repository-relative, slash-separated paths
+// that git status reports as modified, added, renamed or untracked in the
+// repository containing dir, staged or not
+func gitChangedPaths(dir string) (map[string]bool, error) {
+	out, err := gitOutput(dir, "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if len(f) < 4 {
+			continue
+		}
+		xy, p := f[:2], f[3:]
+		// A rename or copy is followed by its original path
+		if xy[0] == 'R' || xy[0] == 'C' {
+			i++
+		}
+		if xy == " D" || xy == "D " || xy == "DD" {
+			continue
+		}
+		changed[p] = true
+	}
+	return changed, nil
+}
+
+// filterGitChanged keeps the files (found under dir) that git reports as
+// changed, so --git-changed still honours --recursive, --max-depth and
+// --exclude-dir
+func filterGitChanged(files []string, dir string) ([]string, error) {
+	changed, err := gitChangedPaths(dir)
+	if err != nil {
+		return nil, fmt.Errorf("git-changed: %w", err)
+	}
+	// Paths from git status are relative to the top level, not dir
+	prefix, err := gitOutput(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("git-changed: %w", err)
+	}
+	base := strings.TrimSpace(string(prefix))
+	var keep []string
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			continue
+		}
+		if changed[path.Join(base, filepath.ToSlash(rel))] {
+			keep = append(keep, f)
+		}
+	}
+	return keep, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// hashDBFlushEvery is how many recorded conversions --hash-db buffers before
+// rewriting the file, so an interrupted run keeps most of its progress
+const hashDBFlushEvery = 100
+
+// hashDB is the --hash-db store: for each source (relative to --directory)
+// the SHA-256 of its content, a fingerprint of the settings it was
+// converted with and the output written. A source whose hash and settings
+// match an entry with an existing output is skipped, whatever its mtime
+// says.
+type hashDB struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]hashEntry
+	pending int // records since the last write
+}
+
+// hashEntry is one --hash-db record
+type hashEntry struct {
+	Hash     string `json:"hash"`
+	Settings string `json:"settings"`
+	Output   string `json:"output"`
+	OutBytes int64  `json:"outBytes"`
+}
+
+// sourceHashes is set by --hash-db
+var sourceHashes *hashDB
+
+// loadHashDB reads path, starting empty when it doesn't exist yet
+func loadHashDB(path string) (*hashDB, error) {
+	db := &hashDB{path: path, entries: map[string]hashEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hash-db: %w", err)
+	}
+	if err := json.Unmarshal(data, &db.entries); err != nil {
+		return nil, fmt.Errorf("hash-db %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// hashSettingsIgnored lists the convertOptions fields that never change
+// what is written for a source: run control, source selection, reporting,
+// and parsed copies of fields that are already part of the key. Every
+// other field goes into hashSettings, so a new option is covered unless it
+// is added here.
+var hashSettingsIgnored = map[string]bool{
+	"overwrite": true, "deleteOriginal": true, "deleteConfirm": true, "interactive": true, "onlyIfSmaller": true,
+	"recursive": true, "maxDepth": true, "excludeDirs": true, "skipFormats": true, "includeWebp": true, "skipUnderBytes": true,
+	"directory": true, "jobsFile": true, "gitChanged": true, "watch": true, "toStdout": true, "urlTimeout": true,
+	"workers": true, "sortBy": true, "newestFirst": true, "previewsFirst": true, "previewOnly": true, "cacheDecoded": true,
+	"export": true, "appendExport": true, "groupBy": true, "phash": true, "inlineThumbnails": true, "inlineMaxBytes": true,
+	"extractFrames": true, "animatedThumbnails": true, "sample": true, "sampleFile": true, "estimate": true, "noWrite": true,
+	"reportDuplicates": true, "copyOthers": true, "hashDB": true, "skipHashesFile": true, "depfile": true,
+	"progressETA": true, "progressJSON": true, "stallTimeout": true, "keepGoingReport": true, "verifySingleDecode": true,
+	"maxFailures": true, "haltOnDiskFull": true, "budget": true, "decodeTimeout": true, "onFailure": true, "hookTimeout": true,
+	"verifyRoundtrip": true, "roundtripTolerance": true,
+	// Applied to the other fields before the key is made
+	"preset": true, "recipe": true,
+	// Parsed forms of string fields in the key
+	"edges": true, "roiSpec": true, "aspect": true, "qualityNameRe": true, "qualities": true, "deriveSpec": true,
+	"sizeClasses": true, "boxW": true, "boxH": true, "dprs": true, "stages": true, "matteColor": true,
+	// Taken from the source's own content
+	"exif": true,
+}
+
+// hashSettings fingerprints every option that changes a file's output, so
+// a rerun with a different quality, size or filter converts again
+func hashSettings(opts convertOptions) string {
+	v := reflect.ValueOf(opts)
+	t := v.Type()
+	h := sha256.New()
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Name; !hashSettingsIgnored[name] {
+			fmt.Fprintf(h, "%s=%v\n", name, v.Field(i))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// check reports whether source was already converted from content hash
+// with settings and its output is still there (unchanged), or was recorded
+// from other content or settings, so its existing output is out of date
+// (stale)
+func (db *hashDB) check(source, hash, settings string) (unchanged, stale bool) {
+	if db == nil {
+		return false, false
+	}
+	db.mu.Lock()
+	e, ok := db.entries[exportKey(source)]
+	db.mu.Unlock()
+	if !ok {
+		return false, false
+	}
+	if e.Hash != hash || e.Settings != settings {
+		return false, true
+	}
+	_, err := os.Stat(filepath.Join(outputRoot(), filepath.FromSlash(e.Output)))
+	return err == nil, false
+}
+
+// record stores a successful conversion, writing the file every
+// hashDBFlushEvery records
+func (db *hashDB) record(source string, stats convertStats) error {
+	if db == nil || stats.srcHash == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(outputRoot(), stats.outPath)
+	if err != nil {
+		rel = stats.outPath
+	}
+	db.mu.Lock()
+	db.entries[exportKey(source)] = hashEntry{
+		Hash:     stats.srcHash,
+		Settings: stats.srcSettings,
+		Output:   filepath.ToSlash(rel),
+		OutBytes: stats.outBytes,
+	}
+	db.pending++
+	flush := db.pending >= hashDBFlushEvery
+	db.mu.Unlock()
+	if flush {
+		return db.write()
+	}
+	return nil
+}
+
+// write replaces the file atomically; keys are sorted by encoding/json
+func (db *hashDB) write() error {
+	if db == nil {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	data, err := json.MarshalIndent(db.entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(db.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("hash-db: %w", err)
+	}
+	db.pending = 0
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// setNonZero stores a value different from the zero value in v, which may
+// be an unexported field
+func setNonZero(t *testing.T, v reflect.Value) {
+	t.Helper()
+	v = reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(7)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(7)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		setNonZero(t, s.Index(0))
+		v.Set(s)
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			setNonZero(t, v.Field(i))
+		}
+	default:
+		t.Fatalf("setNonZero: unhandled kind %s", v.Kind())
+	}
+}
+
+// TestHashSettingsCoversOptions fails when an option that isn't listed in
+// hashSettingsIgnored leaves the --hash-db key unchanged, or when the
+// ignore list names a field that no longer exists
+func TestHashSettingsCoversOptions(t *testing.T) {
+	typ := reflect.TypeOf(convertOptions{})
+	for name := range hashSettingsIgnored {
+		if _, ok := typ.FieldByName(name); !ok {
+			t.Errorf("hashSettingsIgnored lists %q, which is not a convertOptions field", name)
+		}
+	}
+
+	base := hashSettings(convertOptions{})
+	if again := hashSettings(convertOptions{}); again != base {
+		t.Fatalf("hashSettings is not deterministic: %s != %s", base, again)
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		var o convertOptions
+		setNonZero(t, reflect.ValueOf(&o).Elem().Field(i))
+		if !hashSettingsIgnored[f.Name] && hashSettings(o) == base {
+			t.Errorf("%s does not change the --hash-db settings key", f.Name)
+		}
+	}
+}
+
+// TestHashSettingsOutputOptions spot-checks options that were once missing
+// from the key
+func TestHashSettingsOutputOptions(t *testing.T) {
+	base := hashSettings(convertOptions{})
+	for name, o := range map[string]convertOptions{
+		"fit-box":            {fitBox: "100x100"},
+		"target-bpp":         {targetBPP: 1.5},
+		"dpr":                {dprList: "1,2"},
+		"auto-subject-crop":  {autoSubjectCrop: true},
+		"premultiply-output": {premultiplyOutput: true},
+		"pipeline":           {pipeline: "resize,trim,aspect"},
+	} {
+		if hashSettings(o) == base {
+			t.Errorf("--%s does not change the --hash-db settings key", name)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runFailureHook runs the --on-failure command for one failed file. The
+// template is split into arguments on whitespace before {input}, {error}
+// and {code} are filled in, and it is run without a shell, so file names
+// and messages can't inject commands. A hook that fails or times out is
+// reported on stderr and never stops the run.
+func runFailureHook(template string, r convertResult, timeout time.Duration) {
+	fields := strings.Fields(template)
+	if len(fields) == 0 {
+		return
+	}
+	fill := strings.NewReplacer("{input}", r.path, "{error}", r.err.Error(), "{code}", errorCode(r.err))
+	args := make([]string, len(fields))
+	for i, f := range fields {
+		args[i] = fill.Replace(f)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		fmt.Fprintf(os.Stderr, "[HOOK]\t%s: on-failure hook: %v\n", r.path, err)
+	}
+}
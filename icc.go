@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+// --force-srgb converts pixels from the embedded ICC profile to sRGB so the
+// colours survive the profile being dropped. Only matrix/TRC RGB profiles
+// (Display P3, Adobe RGB, ProPhoto, camera sRGB variants) are handled;
+// LUT-based profiles are left untouched and reported.
+
+// readICCProfile returns the ICC profile embedded in a JPEG (APP2) or PNG
+// (iCCP) stream, or nil if there is none
+func readICCProfile(r io.Reader, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return readJPEGICC(r)
+	case "png":
+		return readPNGICC(r)
+	}
+	return nil, nil
+}
+
+// readJPEGICC joins the ICC_PROFILE APP2 segments of a JPEG in sequence order
+func readJPEGICC(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errors.New("not a JPEG")
+	}
+	parts := map[int][]byte{}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("bad JPEG marker")
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			break
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, errors.New("bad JPEG segment length")
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE2 && len(seg) > 14 && string(seg[:12]) == "ICC_PROFILE\x00" {
+			parts[int(seg[12])] = seg[14:]
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	seqs := make([]int, 0, len(parts))
+	for s := range parts {
+		seqs = append(seqs, s)
+	}
+	sort.Ints(seqs)
+	var profile []byte
+	for _, s := range seqs {
+		profile = append(profile, parts[s]...)
+	}
+	return profile, nil
+}
+
+// readPNGICC returns the decompressed iCCP chunk of a PNG
+func readPNGICC(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	sig := make([]byte, 8)
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("not a PNG")
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		typ := string(hdr[4:8])
+		if typ == "IDAT" || typ == "IEND" {
+			return nil, nil
+		}
+		data := make([]byte, int(n)+4) // payload and CRC
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		if typ != "iCCP" {
+			continue
+		}
+		// name, NUL, compression method, zlib stream
+		i := bytes.IndexByte(data[:n], 0)
+		if i < 0 || int(n) < i+2 {
+			return nil, errors.New("bad iCCP chunk")
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[i+2 : n]))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+}
+
+// iccTransform maps a matrix/TRC RGB profile to sRGB
+type iccTransform struct {
+	linear [3][256]float64 // source channel value -> linear light
+	matrix [3][3]float64   // linear source RGB -> linear sRGB
+}
+
+// xyzToLinearSRGB converts D50 PCS XYZ to linear sRGB (Bradford adapted)
+var xyzToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// parseICCTransform builds a transform from an RGB matrix/TRC profile
+func parseICCTransform(profile []byte) (*iccTransform, error) {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil, errors.New("not an ICC profile")
+	}
+	if string(profile[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported ICC colour space %q", profile[16:20])
+	}
+	tags, err := iccTags(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &iccTransform{}
+	var toXYZ [3][3]float64
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[0:4]) != "XYZ " {
+			return nil, errors.New("ICC profile is not matrix/TRC")
+		}
+		for k := 0; k < 3; k++ {
+			toXYZ[k][c] = s15Fixed16(xyz[8+4*k:])
+		}
+		trc, ok := tags[name+"TRC"]
+		if !ok {
+			return nil, errors.New("ICC profile has no TRC")
+		}
+		curve, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		for v := 0; v < 256; v++ {
+			t.linear[c][v] = curve(float64(v) / 255)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[i][j] += xyzToLinearSRGB[i][k] * toXYZ[k][j]
+			}
+		}
+	}
+	return t, nil
+}
+
+// iccTags returns the tag data of a profile keyed by signature
+func iccTags(profile []byte) (map[string][]byte, error) {
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := 0; i < count; i++ {
+		e := 132 + 12*i
+		if e+12 > len(profile) {
+			return nil, errors.New("truncated ICC tag table")
+		}
+		off := int(binary.BigEndian.Uint32(profile[e+4 : e+8]))
+		size := int(binary.BigEndian.Uint32(profile[e+8 : e+12]))
+		if off < 0 || size < 0 || off+size > len(profile) {
+			return nil, errors.New("ICC tag out of range")
+		}
+		tags[string(profile[e:e+4])] = profile[off : off+size]
+	}
+	return tags, nil
+}
+
+// s15Fixed16 reads an ICC signed 15.16 fixed-point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCCurve returns the tone curve of a curv or para tag
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("short ICC curve")
+	}
+	switch string(tag[0:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+2*n {
+			return nil, errors.New("short ICC curve")
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			f := pos - float64(i)
+			return table[i]*(1-f) + table[i+1]*f
+		}, nil
+	case "para":
+		fn := binary.BigEndian.Uint16(tag[8:10])
+		need := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}[fn]
+		if need == 0 || len(tag) < 12+4*need {
+			return nil, errors.New("unsupported ICC parametric curve")
+		}
+		p := make([]float64, 7)
+		for i := 0; i < need; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(x float64) float64 {
+			switch fn {
+			case 0:
+				return math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			default:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported ICC curve type %q", tag[0:4])
+}
+
+// srgbEncoder returns a function mapping linear light to 8-bit sRGB,
+// clamping out-of-gamut values
+func srgbEncoder() func(float64) uint8 {
+	var encode [4096]uint8
+	for i := range encode {
+		v := float64(i) / 4095
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		encode[i] = uint8(math.Round(v * 255))
+	}
+	return func(v float64) uint8 {
+		return encode[int(math.Round(min(max(v, 0), 1)*4095))]
+	}
+}
+
+// apply returns img converted to sRGB
+func (t *iccTransform) apply(img image.Image) *image.NRGBA {
+	toByte := srgbEncoder()
+
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, bl := t.linear[0][c.R], t.linear[1][c.G], t.linear[2][c.B]
+			m := &t.matrix
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: toByte(m[0][0]*r + m[0][1]*g + m[0][2]*bl),
+				G: toByte(m[1][0]*r + m[1][1]*g + m[1][2]*bl),
+				B: toByte(m[2][0]*r + m[2][1]*g + m[2][2]*bl),
+				A: c.A,
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+
+	webp "github.com/chai2010/webp"
+)
+
+// inlineThumbWidths are the widths tried, largest first, for a thumbnail
+// made by --inline-thumbnails; only a blurry placeholder is expected
+var inlineThumbWidths = []int{32, 24, 16, 8}
+
+// webpDataURI returns data as a data: URI
+func webpDataURI(data []byte) string {
+	return "data:image/webp;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// tinyThumbnailURI encodes the largest of inlineThumbWidths (never wider
+// than img) that fits in maxBytes, as a data: URI. It returns "" when even
+// the smallest doesn't fit.
+func tinyThumbnailURI(img image.Image, maxBytes int) (string, error) {
+	for _, w := range inlineThumbWidths {
+		w = min(w, img.Bounds().Dx())
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, scaledToWidth(img, w, true), &webp.Options{Quality: 40}); err != nil {
+			return "", err
+		}
+		if buf.Len() <= maxBytes {
+			return webpDataURI(buf.Bytes()), nil
+		}
+	}
+	return "", nil
+}
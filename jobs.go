@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// jobOverride is one line of a --jobs-file: a source path and the options
+// that differ from the command line for that file alone
+type jobOverride struct {
+	Path     string   `json:"path"`
+	Quality  *float32 `json:"quality"`
+	Width    *int     `json:"width"`
+	Height   *int     `json:"height"`
+	Lossless *bool    `json:"lossless"`
+}
+
+// jobOverrides maps sources listed in --jobs-file to their overrides; set
+// once before the pool starts
+var jobOverrides map[string]jobOverride
+
+// apply returns base with the job's fields set on top
+func (j jobOverride) apply(base convertOptions) convertOptions {
+	if j.Quality != nil {
+		base.quality = *j.Quality
+	}
+	if j.Width != nil {
+		base.maxWidth = *j.Width
+	}
+	if j.Height != nil {
+		base.maxHeight = *j.Height
+	}
+	if j.Lossless != nil {
+		base.lossless = *j.Lossless
+	}
+	return base
+}
+
+// optionsFor returns base merged with any --jobs-file override for path
+func optionsFor(path string, base convertOptions) convertOptions {
+	if j, ok := jobOverrides[path]; ok {
+		return j.apply(base)
+	}
+	return base
+}
+
+// loadJobsFile reads a JSONL jobs file, one object per line, and returns
+// the listed sources in file order. Relative paths are taken from dir.
+// Blank lines are ignored; unknown keys and repeated paths are rejected.
+func loadJobsFile(path, dir string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("jobs-file: %w", err)
+	}
+	defer f.Close()
+
+	jobOverrides = map[string]jobOverride{}
+	var files []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		var j jobOverride
+		if err := dec.Decode(&j); err != nil {
+			return nil, fmt.Errorf("jobs-file %s:%d: %w", path, line, err)
+		}
+		if j.Path == "" {
+			return nil, fmt.Errorf("jobs-file %s:%d: missing path", path, line)
+		}
+		if j.Quality != nil && (*j.Quality < 0 || *j.Quality > 100) {
+			return nil, fmt.Errorf("jobs-file %s:%d: quality must be between 0 and 100", path, line)
+		}
+		if (j.Width != nil && *j.Width < 0) || (j.Height != nil && *j.Height < 0) {
+			return nil, fmt.Errorf("jobs-file %s:%d: width and height must not be negative", path, line)
+		}
+		if !filepath.IsAbs(j.Path) {
+			j.Path = filepath.Join(dir, j.Path)
+		}
+		if _, dup := jobOverrides[j.Path]; dup {
+			return nil, fmt.Errorf("jobs-file %s:%d: %s listed twice", path, line, j.Path)
+		}
+		jobOverrides[j.Path] = j
+		files = append(files, j.Path)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("jobs-file %s: %w", path, err)
+	}
+	return files, nil
+}
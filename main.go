@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,15 +10,21 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	_ "golang.org/x/image/bmp"
-	"golang.org/x/image/draw"
 	_ "golang.org/x/image/tiff"
 
 	webp "github.com/chai2010/webp"
@@ -25,19 +32,132 @@ import (
 )
 
 type convertOptions struct {
-	quality          float32
-	lossless         bool
-	overwrite        bool
-	deleteOriginal   bool
-	recursive        bool
-	workers          int
-	directory        string
-	trim             bool
-	trimThreshold    uint8
-	export           bool
-	maxWidth         int
-	maxHeight        int
-	thumbnailPercent int
+	quality              float32
+	lossless             bool
+	overwrite            bool
+	deleteOriginal       bool
+	recursive            bool
+	workers              int
+	directory            string
+	trim                 bool
+	trimThreshold        uint8
+	trimEdges            string
+	edges                trimEdgeSet
+	export               bool
+	maxWidth             int
+	maxHeight            int
+	thumbnailPercent     int
+	thumbnailQuality     float32
+	losslessMaxBytes     int64
+	minSaving            float64
+	emitTrimBounds       bool
+	outputDir            string
+	urlTimeout           time.Duration
+	preset               string
+	reportDuplicates     bool
+	skipFormats          string
+	includeWebp          bool
+	sample               bool
+	sampleFile           string
+	estimate             bool
+	noWrite              bool // encode only; set internally by --sample
+	roi                  string
+	roiQuality           float32
+	roiSpec              roiSpec
+	requireAspect        string
+	aspectTolerance      float64
+	autoFix              string
+	aspect               float64 // parsed requireAspect
+	tagOutput            bool
+	copyOthers           bool
+	maxDepth             int
+	extractFrames        bool
+	phash                bool
+	noSubsample          bool
+	colors               int
+	stallTimeout         time.Duration
+	toStdout             bool
+	normalizeExif        bool
+	skipUnderBytes       int64
+	progressETA          bool
+	qualityList          string
+	progressiveDownscale bool
+	qualityFromName      string
+	qualityNameRe        *regexp.Regexp // compiled qualityFromName
+	qualities            []float32      // parsed qualityList
+	exif                 []byte         // cleaned EXIF to embed; set per file by --normalize-exif
+	dither               bool
+	interactive          bool
+	onlyIfSmaller        bool // set per file when the user picks overwrite-if-smaller
+	previewsFirst        bool
+	pngIfSmaller         bool
+	sortBy               string
+	newestFirst          bool
+	matte                string
+	recompressThreshold  float64
+	derive               string
+	deriveSpec           *deriveSpec // parsed derive
+	jpegQuality          int
+	minShortSide         int
+	hashDB               string
+	skipHashesFile       string
+	targetBPP            float64
+	bppTolerance         float64
+	sizeClass            string
+	sizeClasses          []sizeClass // parsed sizeClass
+	fitBox               string
+	boxW, boxH           int // parsed fitBox
+	extractPalette       int
+	inlineThumbnails     bool
+	inlineMaxBytes       int
+	animFormat           string
+	cacheDecoded         bool
+	trimEmptyPolicy      string
+	verifySingleDecode   bool
+	progressJSON         bool
+	stripAllExtensions   bool
+	minQuality           float32
+	groupBy              string
+	losslessInclude      []string
+	trimIgnoreSpecks     int
+	trimSymmetric        bool
+	autoSubjectCrop      bool
+	subjectMargin        int
+	subjectThreshold     int
+	nameTemplate         string
+	forceSRGB            bool
+	animatedThumbnails   string
+	keepGoingReport      bool
+	onConflict           string
+	recipe               string
+	excludeDirs          []string
+	appendExport         bool
+	premultiplyOutput    bool
+	watch                string
+	depfile              string
+	jobsFile             string
+	gitChanged           bool
+	maxFailures          int
+	haltOnDiskFull       bool
+	budget               time.Duration
+	verifyRoundtrip      bool
+	roundtripTolerance   int
+	dprList              string
+	dprs                 []int // parsed dprList, ascending
+	xmpQualityField      string
+	xmpTargetField       string
+	targetBytes          int64 // per-file lossy size goal from --xmp-target-bytes
+	maxOutputDim         int
+	cmyk                 bool
+	decodeTimeout        time.Duration
+	contentAddressed     bool
+	onFailure            string
+	hookTimeout          time.Duration
+	pipeline             string
+	deleteConfirm        bool
+	stages               []string    // parsed pipeline
+	matteColor           color.NRGBA // parsed matte
+	previewOnly          bool        // write just the thumbnail; set for the --previews-first pass
 }
 
 var (
@@ -55,9 +175,18 @@ var (
 
 var errSkipped = errors.New("skipped")
 
+// overwritePrompt is set in --interactive mode
+var overwritePrompt *overwritePrompter
+
+// runLog receives the human-readable output of a run; --progress-json
+// points it at stderr so stdout carries only NDJSON
+var runLog io.Writer = os.Stdout
+
 var rootCmd = &cobra.Command{
-	Use:   "image-convert",
-	Short: "Convert images to WebP format",
+	Use:     "image-convert [url...]",
+	Short:   "Convert images to WebP format",
+	Version: version,
+	Args:    cobra.ArbitraryArgs,
 	Long: `A fast and efficient tool to convert various image formats to WebP.
 Supports JPEG, PNG, GIF, BMP, TIFF formats and converts them to WebP with configurable quality and options.
 
@@ -66,55 +195,520 @@ Features:
 - Trim transparent borders from images (similar to Photoshop's Image Trim)
 - Batch processing with concurrent workers
 - Recursive directory processing
-- Optional original file deletion`,
+- Optional original file deletion
+- Convert remote images by passing http(s) URLs as arguments`,
 	RunE: runConvert,
 }
 
 func init() {
+	rootCmd.Flags().StringVar(&opts.qualityList, "qualities", "", "Comma-separated qualities to encode in one pass, written as name.qNN.webp (e.g. 80,60)")
+
+	rootCmd.Flags().StringVar(&opts.qualityFromName, "quality-from-name", "", `Take per-file quality from a filename token matching this regexp (one capture group), stripped from the output name; bare flag uses @q(\d+)`)
+	rootCmd.Flags().Lookup("quality-from-name").NoOptDefVal = `@q(\d+)`
+
+	// Preset flag
+	rootCmd.Flags().StringVar(&opts.preset, "preset", "", "Option bundle: "+strings.Join(presetNames(), ", ")+" (explicit flags override)")
+
+	rootCmd.Flags().StringVar(&opts.recipe, "recipe", "", "JSON file describing trim, resize, format, quality tiers, naming and derived sets (explicit flags override; applied over --preset)")
+
 	// Quality flag
 	rootCmd.Flags().Float32VarP(&opts.quality, "quality", "q", 100, "WebP quality (0-100)")
 
+	rootCmd.Flags().StringVar(&opts.roi, "roi", "", "Region of interest kept at --roi-quality: center or x,y,w,h (lossy only)")
+	rootCmd.Flags().StringVar(&opts.sizeClass, "size-class", "", `Encoding by source file size, first match wins, e.g. "100KB=lossless;2MB=q85;*=q75"; filename and XMP quality hints still override`)
+	rootCmd.Flags().Float64Var(&opts.targetBPP, "target-bpp", 0, "Pick each file's lossy quality so the output is about this many bits per pixel (bytes*8/pixels), e.g. 1.5 (0 = off)")
+	rootCmd.Flags().Float64Var(&opts.bppTolerance, "target-bpp-tolerance", 0.05, "Accept a --target-bpp encode within this fraction of the target; otherwise the closest is kept")
+	rootCmd.Flags().Float32Var(&opts.minQuality, "min-quality", 0, "Floor for automatically chosen lossy qualities (0-100); outputs that hit it are flagged")
+	rootCmd.Flags().Float32Var(&opts.roiQuality, "roi-quality", 90, "Quality inside --roi; the rest is smoothed toward --quality (0-100)")
+
+	rootCmd.Flags().StringVar(&opts.requireAspect, "require-aspect", "", "Fail sources whose aspect ratio isn't W:H (e.g. 3:4)")
+	rootCmd.Flags().Float64Var(&opts.aspectTolerance, "aspect-tolerance", 0.01, "Allowed relative deviation for --require-aspect (0.01 = 1%)")
+	rootCmd.Flags().StringVar(&opts.autoFix, "auto-fix", "", "With --require-aspect, conform mismatches instead of failing: crop or pad")
+
+	rootCmd.Flags().IntVar(&opts.colors, "colors", 0, "Quantize to at most this many colours (2-256, 0 = off)")
+	rootCmd.Flags().BoolVar(&opts.dither, "dither", false, "With --colors, apply Floyd-Steinberg dithering to reduce banding")
+
 	// Boolean flags
 	rootCmd.Flags().BoolVarP(&opts.lossless, "lossless", "l", false, "Use lossless WebP encoding")
+	rootCmd.Flags().BoolVar(&opts.noSubsample, "no-subsample", false, "Keep full chroma resolution by switching to lossless encoding, since lossy WebP is always 4:2:0 (noted for each file)")
 	rootCmd.Flags().BoolVarP(&opts.overwrite, "overwrite", "o", false, "Overwrite existing .webp files if present")
 	rootCmd.Flags().BoolVarP(&opts.deleteOriginal, "delete-original", "d", false, "Delete the original image after successful conversion")
+	rootCmd.Flags().BoolVar(&opts.deleteConfirm, "delete-original-confirm", false, "Allow --delete-original when --output-dir is not the source directory")
+	rootCmd.Flags().BoolVarP(&opts.interactive, "interactive", "i", false, "Ask before replacing each existing output (forces one worker)")
 	rootCmd.Flags().BoolVarP(&opts.recursive, "recursive", "r", false, "Recurse into subdirectories")
+	rootCmd.Flags().StringSliceVar(&opts.excludeDirs, "exclude-dir", nil, "With --recursive, don't descend into directories with this name or path relative to --directory (repeatable, e.g. node_modules)")
+	rootCmd.Flags().IntVar(&opts.maxDepth, "max-depth", -1, "With --recursive, descend at most this many levels (0 = top directory only, -1 = unlimited)")
 	// trim: remove shorthand to free -t for thumbnail
 	rootCmd.Flags().BoolVarP(&opts.trim, "trim", "p", false, "Trim transparent borders from images")
 
 	// Other flags
 	rootCmd.Flags().IntVarP(&opts.workers, "workers", "C", runtime.NumCPU(), "Number of concurrent workers")
+	rootCmd.Flags().BoolVar(&opts.progressJSON, "progress-json", false, "Stream one JSON object per finished file to stdout (path, status, bytes); other output goes to stderr")
+	rootCmd.Flags().BoolVar(&opts.progressETA, "progress-eta", false, "Print progress with an estimated time remaining to stderr")
+	rootCmd.Flags().StringVar(&opts.skipHashesFile, "skip-hashes", "", "File of SHA-256 hashes (one per line, sha256sum output works) of known-bad sources to skip whatever they are named")
+	rootCmd.Flags().StringVar(&opts.hashDB, "hash-db", "", "JSON file of source content hashes and outputs; skip sources whose content and settings are unchanged since they were recorded, whatever their mtime")
+	rootCmd.Flags().BoolVar(&opts.gitChanged, "git-changed", false, "Convert only images that git status reports as modified, added, renamed or untracked (staged or not) in the repository containing --directory; --recursive and the other scan flags still apply")
+	rootCmd.Flags().StringVar(&opts.jobsFile, "jobs-file", "", `Convert the files listed in this JSONL file instead of scanning --directory; each line is {"path":..., "quality":..., "width":..., "height":..., "lossless":...} and overrides the flags for that file`)
+	rootCmd.Flags().StringVar(&opts.depfile, "depfile", "", "Write Makefile-style rules (output: source [recipe]) for every converted file to this path, for make/ninja")
+	rootCmd.Flags().StringVar(&opts.onFailure, "on-failure", "", "Run this command for each failed file, with {input}, {error} and {code} filled in (split on spaces, no shell)")
+	rootCmd.Flags().DurationVar(&opts.hookTimeout, "hook-timeout", 30*time.Second, "Kill an --on-failure command that runs longer than this (0 = no limit)")
+	rootCmd.Flags().BoolVar(&opts.haltOnDiskFull, "halt-on-disk-full", true, "Stop the run at the first disk_full failure instead of failing every remaining file the same way")
+	rootCmd.Flags().DurationVar(&opts.budget, "budget", 0, "Stop starting new files once the run has taken this long (e.g. 5m), finish the ones in flight and report how many are left (0 = no limit)")
+	rootCmd.Flags().IntVar(&opts.maxFailures, "max-failures", 0, "Stop queueing files once this many have failed, finish the in-flight ones and exit with an error (0 = never)")
+	rootCmd.Flags().BoolVar(&opts.keepGoingReport, "keep-going-report", false, "At the end of the run, list every failed file grouped by error code on stderr")
+	rootCmd.Flags().DurationVar(&opts.decodeTimeout, "decode-timeout", 0, "Fail a file as decode_timeout when decoding alone takes longer than this; resize and encode are not limited (0 = off)")
+	rootCmd.Flags().DurationVar(&opts.stallTimeout, "stall-timeout", 0, "Log in-flight files when no file finishes for this long, at least 1s (0 = off)")
 	rootCmd.Flags().StringVarP(&opts.directory, "directory", "D", ".", "Directory to process (default: current directory)")
 	rootCmd.Flags().Uint8VarP(&opts.trimThreshold, "trim-threshold", "T", 0, "Alpha threshold for detecting transparent pixels (0-255, higher = more sensitive)")
+	rootCmd.Flags().BoolVar(&opts.trimSymmetric, "trim-symmetric", false, "With --trim, trim opposite edges by the same amount (the smaller of the two) so content stays centred where it was")
+	rootCmd.Flags().BoolVar(&opts.autoSubjectCrop, "auto-subject-crop", false, "Crop to the box of strong edges (e.g. a product on a plain or uneven background), in the trim stage after --trim")
+	rootCmd.Flags().IntVar(&opts.subjectMargin, "subject-margin", 0, "Pixels kept around the subject found by --auto-subject-crop")
+	rootCmd.Flags().IntVar(&opts.subjectThreshold, "subject-threshold", 96, "Edge strength (Sobel |gx|+|gy| on 8-bit luma, 1-2040) that counts as subject for --auto-subject-crop; raise it for textured backgrounds")
+	rootCmd.Flags().IntVar(&opts.trimIgnoreSpecks, "trim-ignore-specks", 0, "With --trim, ignore isolated specks (8-connected groups) smaller than this many pixels when finding content (0 = off)")
+	rootCmd.Flags().StringVar(&opts.trimEmptyPolicy, "trim-empty-policy", "keep", "With --trim, what to do with fully transparent images: keep (the original), 1x1, or fail")
+	rootCmd.Flags().BoolVar(&opts.emitTrimBounds, "emit-trim-bounds", false, "With --trim, write name.trim.json with the kept region and original/trimmed sizes")
+	rootCmd.Flags().StringVar(&opts.trimEdges, "trim-edges", "all", "Comma-separated edges to trim: top,right,bottom,left or all")
+	rootCmd.Flags().BoolVar(&opts.copyOthers, "copy-others", false, "With --output-dir, copy non-image files into the output tree unchanged")
+	rootCmd.Flags().StringVar(&opts.nameTemplate, "name-template", "", "Output file name without extension; {name}, {width} and {height} (final encoded size) are expanded, e.g. {name}-{width}x{height}")
+	rootCmd.Flags().StringVar(&opts.onConflict, "on-conflict", "ignore", "When several sources map to one output name (a.png, a.jpg -> a.webp): "+strings.Join(conflictPolicies, ", ")+"; ignore lists them as [CONFLICT] and converts each in turn, so the first written keeps the output unless --overwrite")
+	rootCmd.Flags().BoolVar(&opts.stripAllExtensions, "strip-all-extensions", false, "Name outputs up to the first dot (photo.final.jpg -> photo.webp instead of photo.final.webp)")
+	rootCmd.Flags().BoolVar(&opts.contentAddressed, "content-addressed", false, "Name each output by the first 16 hex digits of its SHA-256 (e.g. 3f2a9c0d1e4b5a67.webp) and write manifest.json mapping sources to outputs")
+	rootCmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "Write outputs under this directory, mirroring the source layout; {quality}, {format} and {mode} are expanded (default: next to the source)")
+	rootCmd.Flags().DurationVar(&opts.urlTimeout, "url-timeout", 30*time.Second, "Timeout for fetching http(s) URL arguments")
+	rootCmd.Flags().Int64Var(&opts.skipUnderBytes, "skip-under-bytes", 0, "Skip sources smaller than this many bytes (copied with --copy-others)")
+	rootCmd.Flags().StringVar(&opts.skipFormats, "skip-formats", "", "Comma-separated extensions to leave untouched besides .webp (e.g. gif,bmp)")
+	rootCmd.Flags().BoolVar(&opts.includeWebp, "include-webp", false, "Re-encode .webp sources too, which are otherwise always left untouched")
+	rootCmd.Flags().BoolVar(&opts.sample, "sample", false, "Convert only the largest image in memory and print a detailed report")
+	rootCmd.Flags().StringVar(&opts.sampleFile, "sample-file", "", "Like --sample, but for the given file")
+	rootCmd.Flags().BoolVar(&opts.estimate, "estimate", false, "Encode every image in memory with the current settings and report current versus projected bytes; nothing is written")
+	rootCmd.Flags().BoolVar(&opts.reportDuplicates, "report-duplicates", false, "Hash sources and report identical files, marking a stable canonical copy")
+	rootCmd.Flags().BoolVar(&opts.premultiplyOutput, "premultiply-output", false, "Store colour channels premultiplied by alpha, for game engines that expect premultiplied textures; this changes pixel values, so ordinary viewers show semi-transparent areas darker")
+	rootCmd.Flags().BoolVar(&opts.cmyk, "cmyk", false, "Convert CMYK TIFFs to sRGB through their embedded ICC profile (pure Go, no lcms needed); without it they fail as unsupported")
+	rootCmd.Flags().BoolVar(&opts.forceSRGB, "force-srgb", false, "Convert JPEG/PNG pixels from their embedded ICC profile (e.g. Display P3) to sRGB")
+	rootCmd.Flags().BoolVar(&opts.normalizeExif, "normalize-exif", false, "Rotate JPEGs by their EXIF orientation and re-embed only camera/date EXIF")
+	rootCmd.Flags().StringVar(&opts.xmpQualityField, "xmp-quality", "", "Take per-file quality from this source XMP field (prefix:Name, e.g. exporthints:quality); JPEG, PNG, WebP and TIFF carry XMP")
+	rootCmd.Flags().StringVar(&opts.xmpTargetField, "xmp-target-bytes", "", "Take a per-file size goal in bytes from this source XMP field and pick the highest lossy quality that meets it")
+	rootCmd.Flags().BoolVar(&opts.tagOutput, "tag-output", false, "Embed an XMP note with the quality, source dimensions and tool version in each output (--export reports the dimensions as originalWidth/originalHeight)")
+	rootCmd.Flags().BoolVar(&opts.extractFrames, "extract-frames", false, "Write every frame of each .webp as name_frame_NN.webp and exit")
+	rootCmd.Flags().StringVar(&opts.watch, "watch", "", "Convert this single file and convert it again every time it changes, until interrupted")
+	rootCmd.Flags().BoolVar(&opts.toStdout, "to-stdout", false, "Convert the single file, URL or - (stdin) argument and write the WebP to stdout")
 	rootCmd.Flags().BoolVarP(&opts.export, "export", "e", false, "Export .webp files and write info.json")
+	rootCmd.Flags().BoolVar(&opts.appendExport, "append-export", false, "Like --export, but reuse info.json entries for files unchanged since it was written and drop entries for deleted files")
+	rootCmd.Flags().StringVar(&opts.groupBy, "group-by", "", "With --export, nest info.json entries by group: dir (parent directory relative to --directory)")
+	rootCmd.Flags().IntVar(&opts.extractPalette, "extract-palette", 0, "Record up to this many dominant colours per image as hex, most common first, in info.json (with --export) and manifest.json (0 = off)")
+	rootCmd.Flags().BoolVar(&opts.inlineThumbnails, "inline-thumbnails", false, "With --export, embed each thumbnail in info.json as a base64 data: URI, making a tiny one when name_thumbnail.webp is missing or too big")
+	rootCmd.Flags().IntVar(&opts.inlineMaxBytes, "inline-max-bytes", 2048, "Largest thumbnail --inline-thumbnails embeds; images whose tiny thumbnail is still bigger get none")
+	rootCmd.Flags().BoolVar(&opts.phash, "phash", false, "With --export, record a DCT perceptual hash per image in info.json")
 	rootCmd.Flags().IntVarP(&opts.maxWidth, "width", "w", 0, "Max output width (0 = no limit)")
 	rootCmd.Flags().IntVarP(&opts.maxHeight, "height", "H", 0, "Max output height (0 = no limit)")
+	rootCmd.Flags().StringVar(&opts.dprList, "dpr", "", "Comma-separated pixel densities to write from each source, e.g. 1,2,3 for name.webp, name@2x.webp and name@3x.webp; --width is the 1x width")
+	rootCmd.Flags().StringVar(&opts.fitBox, "fit-box", "", "Scale every output in one step to fit inside WxH touching at least one side (CSS object-fit: contain, upscaling small images); replaces --width/--height")
+	rootCmd.Flags().IntVar(&opts.minShortSide, "min-short-side", 0, "Upscale so the shorter output side is at least this many pixels; --width/--height still cap the result (0 = off)")
+	rootCmd.Flags().StringVar(&opts.pipeline, "pipeline", strings.Join(pipelineStages, ","), "Order of the trim, aspect (--auto-fix) and resize stages; each stage still needs its own flags")
+	rootCmd.Flags().IntVar(&opts.maxOutputDim, "max-output-dim", 0, "Hard cap on either side of every output, applied after all other sizing (e.g. 4096 for GPU texture limits; 0 = off)")
+	rootCmd.Flags().BoolVar(&opts.progressiveDownscale, "progressive-downscale", false, "Halve large images in box-filter steps before the final resize (cleaner big reductions)")
+	rootCmd.Flags().BoolVar(&opts.cacheDecoded, "cache-decoded", false, "With --previews-first, keep decoded images in memory for the full pass instead of decoding twice")
+	rootCmd.Flags().BoolVar(&opts.verifyRoundtrip, "verify-roundtrip", false, "Decode each written WebP and fail the file as roundtrip_mismatch (removing the output) if its pixels differ from what was encoded; meant for --lossless")
+	rootCmd.Flags().IntVar(&opts.roundtripTolerance, "roundtrip-tolerance", 0, "Largest per-channel difference (0-255) --verify-roundtrip accepts")
+	rootCmd.Flags().BoolVar(&opts.verifySingleDecode, "verify-single-decode", false, "Fail the run if any source was decoded more than once (for testing)")
+	rootCmd.Flags().StringVar(&opts.animFormat, "anim-format", "", "Keep animated GIFs animated as webp or apng (name.png, always lossless), preserving loop count and delays; only --width/--height and --max-output-dim apply")
+	rootCmd.Flags().IntVar(&opts.jpegQuality, "jpeg-quality", 0, "Quality of --derive JPEG fallbacks (1-100, 0 = same as --quality); the encoder writes baseline JPEG only")
+	rootCmd.Flags().StringVar(&opts.derive, "derive", "", "Also write a responsive set per image, e.g. \"widths=320,640,1280;fallback=jpeg;placeholder=16\", described in name.set.json")
+	rootCmd.Flags().Float64Var(&opts.recompressThreshold, "recompress-threshold", -1, "With --include-webp, skip .webp sources whose --tag-output quality is within this of --quality (-1 = always re-encode; untagged files are always re-encoded)")
+	rootCmd.Flags().StringVar(&opts.matte, "matte", "", "Soften GIF transparency edges against this #rrggbb colour")
+	rootCmd.Flags().StringVar(&opts.sortBy, "sort-by", "name", "Conversion order: "+strings.Join(sortOrders, ", "))
+	rootCmd.Flags().BoolVar(&opts.newestFirst, "newest-first", false, "Convert the most recently modified files first (same as --sort-by newest)")
+	rootCmd.Flags().BoolVar(&opts.pngIfSmaller, "png-lossless-if-smaller", false, "Keep a PNG source (copied with --output-dir) when its lossless WebP isn't smaller")
+	rootCmd.Flags().StringVar(&opts.animatedThumbnails, "animated-thumbnails", "first-frame", "Thumbnails of existing animated .webp files: first-frame, animated, or skip")
+	rootCmd.Flags().BoolVar(&opts.previewsFirst, "previews-first", false, "With --thumbnail, write every thumbnail in a fast first pass before the full conversions")
 	rootCmd.Flags().IntVarP(&opts.thumbnailPercent, "thumbnail", "t", 0, "Thumbnail percent size (1-100). Creates name_thumbnail.webp")
+	rootCmd.Flags().Float32Var(&opts.thumbnailQuality, "thumbnail-quality", -1, "Lossy WebP quality for thumbnails (0-100), even with --lossless (-1 = same as the image)")
+	rootCmd.Flags().Float64Var(&opts.minSaving, "min-saving", 0, "Keep the original unless the WebP is at least this percent smaller (0-100)")
+	rootCmd.Flags().StringSliceVar(&opts.losslessInclude, "lossless-include", nil, "Encode files whose name matches any of these globs losslessly (e.g. \"*-logo.*\"), whatever --lossless says")
+	rootCmd.Flags().Int64Var(&opts.losslessMaxBytes, "lossless-max-bytes", 0, "Re-encode lossy at --quality when a lossless output exceeds this size (0 = no limit)")
 
 	// Mark directory flag as required
 	rootCmd.MarkFlagRequired("directory")
 }
 
 func runConvert(cmd *cobra.Command, args []string) error {
+	runStart := time.Now()
 	// Export mode outputs info.json and exits
-	if opts.export {
+	if opts.export || opts.appendExport {
 		return runExport()
 	}
+	if opts.extractFrames {
+		return runExtractFrames()
+	}
+	// Apply preset values for flags not given explicitly
+	if opts.preset != "" {
+		if err := applyPreset(opts.preset, &opts, cmd.Flags()); err != nil {
+			return err
+		}
+	}
+	if opts.recipe != "" {
+		if err := applyRecipe(opts.recipe, &opts, cmd.Flags()); err != nil {
+			return err
+		}
+	}
+
 	// Validate quality range
 	if opts.quality < 0 || opts.quality > 100 {
 		return fmt.Errorf("quality must be between 0 and 100")
 	}
+	for _, pattern := range opts.losslessInclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid lossless-include pattern %q: %w", pattern, err)
+		}
+	}
+	if opts.minQuality < 0 || opts.minQuality > 100 {
+		return fmt.Errorf("min-quality must be between 0 and 100")
+	}
+	if !opts.lossless && opts.quality < opts.minQuality {
+		return fmt.Errorf("quality %g is below --min-quality %g", opts.quality, opts.minQuality)
+	}
+
+	if opts.qualityList != "" {
+		qualities, err := parseQualities(opts.qualityList)
+		if err != nil {
+			return err
+		}
+		opts.qualities = qualities
+	}
+
+	if opts.qualityFromName != "" {
+		re, err := regexp.Compile(opts.qualityFromName)
+		if err != nil {
+			return fmt.Errorf("invalid quality-from-name pattern: %w", err)
+		}
+		if re.NumSubexp() != 1 {
+			return fmt.Errorf("quality-from-name pattern needs exactly one capture group")
+		}
+		opts.qualityNameRe = re
+	}
 
 	// Validate workers
 	if opts.workers < 1 {
 		return fmt.Errorf("workers must be at least 1")
 	}
 
-	files, err := collectImageFiles(opts.directory, opts.recursive)
+	if opts.minSaving < 0 || opts.minSaving > 100 {
+		return fmt.Errorf("min-saving must be between 0 and 100")
+	}
+
+	// chai2010/webp exposes no chroma settings and VP8 lossy is 4:2:0 only;
+	// full chroma is available only in the lossless (VP8L) bitstream
+	subsampleLossless := opts.noSubsample && !opts.lossless
+	if opts.noSubsample {
+		opts.lossless = true
+	}
+
+	if opts.maxOutputDim < 0 {
+		return fmt.Errorf("max-output-dim must not be negative")
+	}
+
+	if opts.losslessMaxBytes < 0 {
+		return fmt.Errorf("lossless-max-bytes must not be negative")
+	}
+
+	edges, err := parseTrimEdges(opts.trimEdges)
+	if err != nil {
+		return err
+	}
+	opts.edges = edges
+
+	if opts.stages, err = parsePipeline(opts.pipeline); err != nil {
+		return err
+	}
+
+	if opts.requireAspect != "" {
+		if opts.aspect, err = parseAspect(opts.requireAspect); err != nil {
+			return err
+		}
+		if opts.aspectTolerance < 0 {
+			return fmt.Errorf("aspect-tolerance must not be negative")
+		}
+	}
+	if opts.autoFix != "" && opts.autoFix != "crop" && opts.autoFix != "pad" {
+		return fmt.Errorf("auto-fix must be crop or pad")
+	}
+
+	if opts.colors != 0 && (opts.colors < 2 || opts.colors > 256) {
+		return fmt.Errorf("colors must be between 2 and 256")
+	}
+	if opts.dither && opts.colors == 0 {
+		return fmt.Errorf("dither requires --colors")
+	}
+	if opts.nameTemplate != "" {
+		if !strings.Contains(opts.nameTemplate, "{name}") {
+			return fmt.Errorf("name-template must contain {name}")
+		}
+		if strings.ContainsAny(opts.nameTemplate, `/\`) {
+			return fmt.Errorf("name-template must not contain path separators")
+		}
+	}
+	if opts.trimIgnoreSpecks < 0 {
+		return fmt.Errorf("trim-ignore-specks must not be negative")
+	}
+	if opts.sizeClass != "" {
+		if opts.sizeClasses, err = parseSizeClasses(opts.sizeClass); err != nil {
+			return err
+		}
+	}
+	if opts.targetBPP < 0 {
+		return fmt.Errorf("target-bpp must not be negative")
+	}
+	if opts.bppTolerance < 0 {
+		return fmt.Errorf("target-bpp-tolerance must not be negative")
+	}
+	if opts.targetBPP > 0 && len(opts.qualities) > 0 {
+		return fmt.Errorf("target-bpp cannot be combined with --qualities")
+	}
+	if opts.fitBox != "" {
+		if opts.maxWidth > 0 || opts.maxHeight > 0 || opts.minShortSide > 0 {
+			return fmt.Errorf("fit-box cannot be combined with --width, --height or --min-short-side")
+		}
+		if opts.boxW, opts.boxH, err = parseBox(opts.fitBox); err != nil {
+			return err
+		}
+	}
+	if opts.dprList != "" {
+		if opts.maxWidth == 0 {
+			return fmt.Errorf("dpr requires --width as the 1x width")
+		}
+		if len(opts.qualities) > 0 || opts.contentAddressed || opts.animFormat != "" {
+			return fmt.Errorf("dpr cannot be combined with --qualities, --content-addressed or --anim-format")
+		}
+		if opts.dprs, err = parseDPRs(opts.dprList); err != nil {
+			return err
+		}
+	}
+	if opts.extractPalette < 0 || opts.extractPalette > 256 {
+		return fmt.Errorf("extract-palette must be between 0 and 256")
+	}
+	if opts.minShortSide < 0 {
+		return fmt.Errorf("min-short-side must not be negative")
+	}
+	if opts.roundtripTolerance < 0 || opts.roundtripTolerance > 255 {
+		return fmt.Errorf("roundtrip-tolerance must be between 0 and 255")
+	}
+	if opts.subjectMargin < 0 {
+		return fmt.Errorf("subject-margin must not be negative")
+	}
+	if opts.subjectThreshold < 1 || opts.subjectThreshold > 2040 {
+		return fmt.Errorf("subject-threshold must be between 1 and 2040")
+	}
+	if opts.gitChanged && opts.jobsFile != "" {
+		return fmt.Errorf("git-changed cannot be combined with --jobs-file")
+	}
+	if opts.budget < 0 {
+		return fmt.Errorf("budget must not be negative")
+	}
+	if opts.maxFailures < 0 {
+		return fmt.Errorf("max-failures must not be negative")
+	}
+	if opts.stallTimeout != 0 && opts.stallTimeout < minStallTimeout {
+		return fmt.Errorf("stall-timeout must be 0 or at least %s", minStallTimeout)
+	}
+	for _, field := range []string{opts.xmpQualityField, opts.xmpTargetField} {
+		if field != "" && !xmpFieldRe.MatchString(field) {
+			return fmt.Errorf("XMP field %q must look like prefix:Name", field)
+		}
+	}
+	switch opts.animatedThumbnails {
+	case "first-frame", "animated", "skip":
+	default:
+		return fmt.Errorf("animated-thumbnails must be first-frame, animated or skip")
+	}
+	switch opts.trimEmptyPolicy {
+	case "keep", "1x1", "fail":
+	default:
+		return fmt.Errorf("trim-empty-policy must be keep, 1x1 or fail")
+	}
+	switch opts.animFormat {
+	case "", "webp", "apng":
+	default:
+		return fmt.Errorf("anim-format must be webp or apng")
+	}
+	if opts.animFormat != "" && len(opts.qualities) > 0 {
+		return fmt.Errorf("anim-format cannot be combined with --qualities")
+	}
+	if opts.derive != "" {
+		if opts.deriveSpec, err = parseDeriveSpec(opts.derive); err != nil {
+			return err
+		}
+	}
+	if opts.jpegQuality < 0 || opts.jpegQuality > 100 {
+		return fmt.Errorf("jpeg-quality must be between 1 and 100, or 0 to follow --quality")
+	}
+	if opts.matte != "" {
+		if opts.matteColor, err = parseHexColor(opts.matte); err != nil {
+			return err
+		}
+	}
+	if opts.newestFirst {
+		if cmd.Flags().Changed("sort-by") && opts.sortBy != "newest" {
+			return fmt.Errorf("newest-first conflicts with --sort-by %s", opts.sortBy)
+		}
+		opts.sortBy = "newest"
+	}
+	if !slices.Contains(conflictPolicies, opts.onConflict) {
+		return fmt.Errorf("on-conflict must be one of %s", strings.Join(conflictPolicies, ", "))
+	}
+	if !slices.Contains(sortOrders, opts.sortBy) {
+		return fmt.Errorf("sort-by must be one of %s", strings.Join(sortOrders, ", "))
+	}
+	if opts.contentAddressed && len(opts.qualities) > 0 {
+		return fmt.Errorf("content-addressed cannot be combined with --qualities")
+	}
+	if opts.hashDB != "" && len(opts.qualities) > 0 {
+		return fmt.Errorf("hash-db cannot be combined with --qualities")
+	}
+	if opts.thumbnailQuality > 100 || (opts.thumbnailQuality < 0 && opts.thumbnailQuality != -1) {
+		return fmt.Errorf("thumbnail-quality must be between 0 and 100")
+	}
+	if opts.previewsFirst && opts.thumbnailPercent == 0 {
+		return fmt.Errorf("previews-first requires --thumbnail")
+	}
+	if opts.previewsFirst && opts.interactive {
+		return fmt.Errorf("previews-first cannot be combined with --interactive")
+	}
+
+	// Expand {quality}, {format} and {mode} placeholders in --output-dir
+	opts.outputDir = expandOutputDir(opts.outputDir, opts)
+
+	// Building a separate output tree almost never means wiping the sources
+	if opts.deleteOriginal && !opts.deleteConfirm && opts.outputDir != "" && !sameDir(opts.outputDir, opts.directory) {
+		return fmt.Errorf("delete-original with --output-dir %s would remove the sources in %s; add --delete-original-confirm if that is intended", opts.outputDir, opts.directory)
+	}
+
+	if opts.roi != "" {
+		if opts.roiQuality < 0 || opts.roiQuality > 100 {
+			return fmt.Errorf("roi-quality must be between 0 and 100")
+		}
+		if opts.roiSpec, err = parseROI(opts.roi); err != nil {
+			return err
+		}
+	}
+
+	if opts.toStdout && opts.progressJSON {
+		return fmt.Errorf("progress-json cannot be combined with --to-stdout")
+	}
+	if opts.watch != "" && opts.deleteOriginal {
+		return fmt.Errorf("watch cannot be combined with --delete-original")
+	}
+	if opts.copyOthers && opts.outputDir == "" {
+		return fmt.Errorf("copy-others requires --output-dir")
+	}
+
+	// The flags are valid; later errors are runtime failures such as a full
+	// disk, which the usage text would only bury. main prints the error.
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+
+	if opts.toStdout {
+		return runToStdout(args)
+	}
+
+	if opts.watch != "" {
+		return runWatch(opts.watch)
+	}
+
+	// Keep stdout pure NDJSON: every human-readable line goes to stderr
+	var progress *progressJSON
+	if opts.progressJSON {
+		progress = newProgressJSON(os.Stdout)
+		runLog = os.Stderr
+	}
+
+	// Prompts can't interleave, so interactive mode runs one file at a time
+	if opts.interactive && !opts.overwrite {
+		opts.workers = 1
+		overwritePrompt = newOverwritePrompter(os.Stdin, runLog)
+	}
+
+	// Load these before URLs, --sample and --estimate so they skip what a
+	// directory run would
+	if opts.hashDB != "" {
+		if sourceHashes, err = loadHashDB(opts.hashDB); err != nil {
+			return err
+		}
+	}
+	if opts.skipHashesFile != "" {
+		if skipHashes, err = loadSkipHashes(opts.skipHashesFile); err != nil {
+			return err
+		}
+	}
+
+	// URL arguments are converted instead of scanning --directory
+	if len(args) > 0 {
+		return runURLs(args, progress)
+	}
+
+	if opts.sampleFile != "" {
+		return runSample(nil)
+	}
+
+	var files []string
+	if opts.jobsFile != "" {
+		files, err = loadJobsFile(opts.jobsFile, opts.directory)
+	} else {
+		files, err = collectImageFiles(opts.directory, opts.recursive, skipFormatSet())
+		if err == nil && opts.gitChanged {
+			files, err = filterGitChanged(files, opts.directory)
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("error collecting files: %w", err)
 	}
 
+	// Tiny files rarely shrink, so leave them as they are
+	var small []string
+	if opts.skipUnderBytes > 0 {
+		files, small = splitBySize(files, opts.skipUnderBytes)
+	}
+
+	if opts.sample {
+		return runSample(files)
+	}
+	if opts.estimate {
+		return runEstimate(files)
+	}
+
+	if err := sortFiles(files, opts.sortBy); err != nil {
+		return err
+	}
+
+	// Two sources must never write the same output. Content-addressed
+	// names only coincide for identical bytes.
+	var conflicted []outputConflict
+	if !opts.contentAddressed {
+		if files, conflicted, err = resolveOutputConflicts(files, opts.onConflict); err != nil {
+			return err
+		}
+	}
+	for _, c := range conflicted {
+		printSkip(c.path, fmt.Sprintf("output %s already claimed by %s", c.outPath, c.owner))
+	}
+	for _, p := range files {
+		if out, ok := outputRenames[p]; ok {
+			fmt.Fprintf(runLog, "[RENAME]\t%s -> %s\n", p, out)
+		}
+	}
+
+	// Fail fast instead of reporting the same permission error per file
+	if err := checkWritable(outputRoot()); err != nil {
+		return err
+	}
+
+	if opts.copyOthers {
+		if err := copyOtherFiles(opts.directory, opts.recursive, skipFormatSet(), files, small); err != nil {
+			return err
+		}
+	}
+	for _, p := range small {
+		printSkip(p, fmt.Sprintf("under %d bytes", opts.skipUnderBytes))
+	}
+
 	if len(files) == 0 {
 		if opts.thumbnailPercent > 0 {
 			if err := generateThumbnailsForWebps(opts.directory, opts.recursive, opts); err != nil {
@@ -122,64 +716,228 @@ func runConvert(cmd *cobra.Command, args []string) error {
 			}
 			return nil
 		}
-		fmt.Println("No images found to convert.")
+		fmt.Fprintln(runLog, "No images found to convert.")
 		return nil
 	}
 
-	total := len(files)
-	fmt.Printf("Found %d image(s). Converting to WebP...\n", total)
+	// Hash before converting since --delete-original may remove sources
+	var duplicates []duplicateGroup
+	if opts.reportDuplicates {
+		duplicates, err = findDuplicates(files)
+		if err != nil {
+			return err
+		}
+	}
 
-	jobs := make(chan string)
-	var wg sync.WaitGroup
+	total := len(files)
+	fmt.Fprintf(runLog, "Found %d image(s). Converting to WebP...\n", total)
 
-	type result struct {
-		path string
-		err  error
+	var eta *etaTracker
+	if opts.progressETA {
+		eta = newETATracker(files)
 	}
-	results := make(chan result)
 
-	workerCount := opts.workers
-	if workerCount < 1 {
-		workerCount = 1
+	if opts.cacheDecoded && opts.previewsFirst {
+		sourceCache = &decodedCache{m: map[string]decodedSource{}}
 	}
-
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for path := range jobs {
-				err := convertOne(path, opts)
-				results <- result{path: path, err: err}
-			}
-		}()
+	if opts.verifySingleDecode {
+		decodeCounts = &decodeCounter{n: map[string]int{}}
+	}
+	if opts.previewsFirst {
+		runPreviews(files)
 	}
 
+	pool := newConverterPool(opts.workers, opts)
 	go func() {
 		for _, f := range files {
-			jobs <- f
+			if !pool.Enqueue(f) {
+				break
+			}
 		}
-		close(jobs)
-		wg.Wait()
-		close(results)
+		pool.Close()
 	}()
 
+	// Stop queueing once the run's time is up; in-flight files still finish
+	var budgetSpent atomic.Bool
+	if opts.budget > 0 {
+		// Collecting and hashing sources count against the budget too
+		budgetTimer := time.AfterFunc(opts.budget-time.Since(runStart), func() {
+			budgetSpent.Store(true)
+			pool.Stop()
+		})
+		defer budgetTimer.Stop()
+	}
+
+	// Report which files are stuck if the run stops making progress
+	var watchdog *stallWatchdog
+	if opts.stallTimeout > 0 {
+		watchdog = newStallWatchdog(pool, opts.stallTimeout)
+		defer watchdog.Stop()
+	}
+
+	var failures *failureReport
+	if opts.keepGoingReport {
+		failures = newFailureReport()
+	}
+	var deps *depfile
+	if opts.depfile != "" {
+		deps = newDepfile()
+	}
+	var manifest contentManifest
+	if opts.contentAddressed {
+		manifest = contentManifest{}
+	}
 	converted := 0
 	failed := 0
-	for r := range results {
+	processed := 0
+	abortReason := ""
+	formats := map[string]int{}
+	var trim trimSummary
+	var totals byteTotals
+	var savings savingsHistogram
+	for r := range pool.Results() {
+		processed++
+		if watchdog != nil {
+			watchdog.Progress()
+		}
+		if eta != nil {
+			eta.complete(r.path)
+		}
+		if progress != nil {
+			progress.emit(r)
+		}
 		if r.err != nil {
 			if errors.Is(r.err, errSkipped) {
-				fmt.Printf("[SKIP]\t%s\n", r.path)
+				printSkip(r.path, r.stats.skipReason)
+				// The existing output still depends on its source
+				if deps != nil && r.stats.outputKept {
+					deps.add(r.path, r.stats)
+				}
 				continue
 			}
 			failed++
-			fmt.Fprintf(os.Stderr, "[FAIL]\t%s: %v\n", r.path, r.err)
+			fmt.Fprintf(os.Stderr, "[FAIL]\t%s [%s]: %v\n", r.path, errorCode(r.err), r.err)
+			if failures != nil {
+				failures.add(r)
+			}
+			if opts.onFailure != "" {
+				runFailureHook(opts.onFailure, r, opts.hookTimeout)
+			}
+			// Stop queueing but keep draining so in-flight files are reported
+			if abortReason == "" {
+				switch {
+				case opts.haltOnDiskFull && errors.Is(r.err, errDiskFull):
+					abortReason = fmt.Sprintf("disk full writing under %s (free space and rerun)", outputRoot())
+				case opts.maxFailures > 0 && failed >= opts.maxFailures:
+					abortReason = fmt.Sprintf("aborted after %d failures (--max-failures)", failed)
+				}
+				if abortReason != "" {
+					pool.Stop()
+					fmt.Fprintf(os.Stderr, "[ABORT]\t%s; waiting for in-flight files\n", abortReason)
+				}
+			}
 		} else {
 			converted++
-			fmt.Printf("[OK]\t%s\n", r.path)
+			formats[r.stats.format]++
+			trim.add(r.stats)
+			totals.add(r.stats)
+			savings.add(r.stats)
+			if deps != nil {
+				deps.add(r.path, r.stats)
+			}
+			if manifest != nil {
+				manifest.add(r.path, r.stats)
+			}
+			if err := sourceHashes.record(r.path, r.stats); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			var notes []string
+			if r.stats.downgraded {
+				notes = append(notes, fmt.Sprintf("lossless %d bytes over cap, encoded lossy", r.stats.losslessBytes))
+			}
+			if len(opts.losslessInclude) > 0 {
+				notes = append(notes, encodeMode(r.stats))
+			}
+			if subsampleLossless && r.stats.lossless {
+				notes = append(notes, "encoded lossless for --no-subsample")
+			}
+			if r.stats.colorNote != "" {
+				notes = append(notes, r.stats.colorNote)
+			}
+			if r.stats.sizeClass != "" {
+				notes = append(notes, "size class "+r.stats.sizeClass)
+			}
+			if r.stats.xmpNote != "" {
+				notes = append(notes, r.stats.xmpNote)
+				if len(opts.losslessInclude) == 0 {
+					notes = append(notes, encodeMode(r.stats))
+				}
+			}
+			if r.stats.bpp > 0 && !r.stats.downgraded {
+				notes = append(notes, fmt.Sprintf("%.3f bpp at q%g", r.stats.bpp, r.stats.quality))
+			}
+			if r.stats.overTarget {
+				notes = append(notes, fmt.Sprintf("%d bytes, over the XMP target even at the lowest quality", r.stats.outBytes))
+			}
+			if r.stats.subjectCropped {
+				notes = append(notes, "cropped to subject")
+			}
+			if r.stats.opaqueAlpha {
+				notes = append(notes, "alpha fully opaque, not stored")
+			}
+			if len(r.stats.dprCapped) > 0 {
+				notes = append(notes, dprNote(r.stats.dprCapped, r.stats.srcWidth))
+			}
+			if r.stats.qualityFloored {
+				notes = append(notes, fmt.Sprintf("quality raised to --min-quality %g", opts.minQuality))
+			}
+			if len(notes) > 0 {
+				fmt.Fprintf(runLog, "[OK]\t%s (%s)\n", r.path, strings.Join(notes, "; "))
+				continue
+			}
+			fmt.Fprintf(runLog, "[OK]\t%s\n", r.path)
 		}
 	}
 
-	fmt.Printf("Done. Converted: %d, Failed: %d\n", converted, failed)
+	fmt.Fprintf(runLog, "Done. Converted: %d, Failed: %d\n", converted, failed)
+	if budgetSpent.Load() && processed < total {
+		fmt.Fprintf(runLog, "Budget: %s spent, %d of %d file(s) not started; rerun to continue\n", opts.budget, total-processed, total)
+	}
+	if len(formats) > 0 {
+		fmt.Fprintf(runLog, "Formats: %s\n", formatBreakdown(formats))
+		fmt.Fprintf(runLog, "Bytes: %s\n", &totals)
+		savings.print(runLog)
+	}
+	if progress != nil {
+		progress.summary(&savings)
+	}
+	if opts.trim && converted > 0 {
+		fmt.Fprintf(runLog, "Trim: %s\n", trim)
+	}
+	if opts.reportDuplicates {
+		printDuplicates(duplicates)
+	}
+	if failures != nil {
+		failures.print(os.Stderr)
+	}
+	if deps != nil {
+		if err := deps.write(opts.depfile); err != nil {
+			return err
+		}
+	}
+	if manifest != nil {
+		dest, err := manifest.write()
+		if err != nil {
+			return fmt.Errorf("content manifest: %w", err)
+		}
+		fmt.Fprintf(runLog, "Manifest: %d entries in %s\n", len(manifest), dest)
+	}
+	if err := sourceHashes.write(); err != nil {
+		return err
+	}
+	if abortReason != "" {
+		return fmt.Errorf("%s: %d of %d files processed", abortReason, processed, total)
+	}
 
 	// If thumbnail requested, also create thumbnails for any existing .webp files
 	if opts.thumbnailPercent > 0 {
@@ -188,143 +946,286 @@ func runConvert(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	return nil
+	return decodeCounts.check()
+}
+
+// exportInfo is one info.json entry
+type exportInfo struct {
+	Name            string   `json:"name"`
+	Path            string   `json:"path,omitempty"` // relative key, recorded by --append-export
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	Mime            string   `json:"mime"`
+	Thumbnail       bool     `json:"thumbnail"`
+	ThumbnailWidth  int      `json:"thumbnailWidth"`
+	ThumbnailHeight int      `json:"thumbnailHeight"`
+	PHash           string   `json:"phash,omitempty"`
+	Palette         []string `json:"palette,omitempty"`       // --extract-palette
+	ThumbnailData   string   `json:"thumbnailData,omitempty"` // data: URI, --inline-thumbnails
+	Quality         float64  `json:"quality,omitempty"`
+	DPR             int      `json:"dpr,omitempty"`            // pixel density of a --dpr name@2x.webp
+	OriginalWidth   int      `json:"originalWidth,omitempty"`  // source size before resize, from --tag-output
+	OriginalHeight  int      `json:"originalHeight,omitempty"` // source size before resize, from --tag-output
 }
 
 func runExport() error {
+	if opts.groupBy != "" && opts.groupBy != "dir" {
+		return fmt.Errorf("group-by must be dir")
+	}
+	if opts.inlineThumbnails && opts.inlineMaxBytes < 1 {
+		return fmt.Errorf("inline-max-bytes must be positive")
+	}
 	files, err := collectWebpFiles(opts.directory, opts.recursive)
 	if err != nil {
 		return fmt.Errorf("error collecting .webp files: %w", err)
 	}
-	type info struct {
-		Name            string `json:"name"`
-		Width           int    `json:"width"`
-		Height          int    `json:"height"`
-		Mime            string `json:"mime"`
-		Thumbnail       bool   `json:"thumbnail"`
-		ThumbnailWidth  int    `json:"thumbnailWidth"`
-		ThumbnailHeight int    `json:"thumbnailHeight"`
+	// Sort by slash-separated relative path so info.json diffs cleanly
+	// regardless of walk order or platform
+	sort.Slice(files, func(i, j int) bool {
+		return exportKey(files[i]) < exportKey(files[j])
+	})
+	dest := filepath.Join(opts.directory, "info.json")
+
+	// Entries from the last export are reused for files not modified since
+	var prev map[string]exportInfo
+	var since time.Time
+	if opts.appendExport {
+		if prev, since, err = loadExport(dest); err != nil {
+			return err
+		}
 	}
-	out := make([]info, 0, len(files))
+
+	var paths, keys []string // source and exportKey of each entry in out
+	var out []exportInfo
+	var todo []int // entries in out that need probing
+	kept := 0
 	for _, p := range files {
-		f, err := os.Open(p)
-		if err != nil {
-			return fmt.Errorf("open %s: %w", p, err)
-		}
-		cfg, err := webp.DecodeConfig(f)
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("decode config %s: %w", p, err)
-		}
 		base := filepath.Base(p)
-		isThumb := strings.HasSuffix(strings.ToLower(base), "_thumbnail.webp")
-
 		// Skip exporting thumbnail files themselves
-		if isThumb {
+		if strings.HasSuffix(strings.ToLower(base), "_thumbnail.webp") {
 			continue
 		}
-
-		thumbW := 0
-		thumbH := 0
-		{
-			thumbPath := strings.TrimSuffix(p, ".webp") + "_thumbnail.webp"
-			if st, err := os.Stat(thumbPath); err == nil && !st.IsDir() {
-				thumbFile, err := os.Open(thumbPath)
-				if err == nil {
-					if tcfg, err := webp.DecodeConfig(thumbFile); err == nil {
-						thumbW, thumbH = tcfg.Width, tcfg.Height
-					}
-					thumbFile.Close()
-				}
-			}
+		key := exportKey(p)
+		e, ok := prev[key]
+		if ok {
+			kept++
 		}
-
-		out = append(out, info{
-			Name:            base,
-			Width:           cfg.Width,
-			Height:          cfg.Height,
-			Mime:            "image/webp",
-			Thumbnail:       thumbW > 0 && thumbH > 0,
-			ThumbnailWidth:  thumbW,
-			ThumbnailHeight: thumbH,
-		})
+		if !ok || modifiedSince(p, since) || (opts.phash && e.PHash == "") || (opts.extractPalette > 0 && len(e.Palette) == 0) ||
+			(opts.inlineThumbnails && e.ThumbnailData == "") {
+			todo = append(todo, len(out))
+		}
+		paths = append(paths, p)
+		keys = append(keys, key)
+		out = append(out, e)
 	}
-	data, err := json.MarshalIndent(out, "", "\t")
-	if err != nil {
+	// Probing is I/O bound, so it pays off even on a few cores
+	if err := probeExports(paths, todo, out, opts.workers); err != nil {
 		return err
 	}
-	dest := filepath.Join(opts.directory, "info.json")
-	tmp := dest + ".tmp"
-	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+	probed := len(todo)
+	if opts.appendExport {
+		for i := range out {
+			out[i].Path = keys[i]
+		}
+	}
+	var doc any = out
+	if opts.groupBy == "dir" {
+		// Key entries by their directory relative to --directory ("." for the top)
+		groups := map[string][]exportInfo{}
+		for i, e := range out {
+			dir := path.Dir(keys[i])
+			groups[dir] = append(groups[dir], e)
+		}
+		doc = groups
+	}
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
 		return err
 	}
-	if err := os.Rename(tmp, dest); err != nil {
-		_ = os.Remove(tmp)
+	if err := writeFileAtomic(dest, append(data, '\n')); err != nil {
 		return err
 	}
-	fmt.Printf("Wrote %d entries to %s\n", len(out), dest)
+	if opts.appendExport {
+		fmt.Fprintf(runLog, "Wrote %d entries to %s (%d probed, %d reused, %d dropped)\n", len(out), dest, probed, len(out)-probed, len(prev)-kept)
+		return nil
+	}
+	fmt.Fprintf(runLog, "Wrote %d entries to %s\n", len(out), dest)
 	return nil
 }
 
-func collectImageFiles(root string, recursive bool) ([]string, error) {
-	allowed := map[string]struct{}{
-		".jpg":  {},
-		".jpeg": {},
-		".png":  {},
-		".gif":  {},
-		".bmp":  {},
-		".tif":  {},
-		".tiff": {},
-		".webp": {}, // we will skip converting these but allow discovery for filtering
+// probeExport reads the info.json entry for one .webp file
+func probeExport(p string) (exportInfo, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return exportInfo{}, fmt.Errorf("open %s: %w", p, err)
 	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return exportInfo{}, fmt.Errorf("decode config %s: %w", p, err)
+	}
+	base := filepath.Base(p)
+	origW, origH, _ := readOriginalSize(data)
 
-	var paths []string
-	if recursive {
-		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() {
-				// Skip hidden directories like .git, .cache, etc.
-				if isHidden(d.Name()) && path != "." {
-					return filepath.SkipDir
+	thumbW := 0
+	thumbH := 0
+	var thumbData []byte
+	{
+		thumbPath := strings.TrimSuffix(p, ".webp") + "_thumbnail.webp"
+		if st, err := os.Stat(thumbPath); err == nil && !st.IsDir() {
+			if td, err := os.ReadFile(thumbPath); err == nil {
+				if tcfg, err := webp.DecodeConfig(bytes.NewReader(td)); err == nil {
+					thumbW, thumbH = tcfg.Width, tcfg.Height
+					thumbData = td
 				}
-				return nil
-			}
-			if isHidden(d.Name()) {
-				return nil
 			}
-			ext := strings.ToLower(filepath.Ext(d.Name()))
-			if _, ok := allowed[ext]; ok {
-				// Skip already webp
-				if ext == ".webp" {
-					return nil
-				}
-				paths = append(paths, path)
+		}
+	}
+
+	// An existing thumbnail small enough is inlined as is; otherwise a tiny
+	// one is made from the image
+	inline := ""
+	if opts.inlineThumbnails && len(thumbData) > 0 && len(thumbData) <= opts.inlineMaxBytes {
+		inline = webpDataURI(thumbData)
+	}
+
+	phash := ""
+	var palette []string
+	if opts.phash || opts.extractPalette > 0 || (opts.inlineThumbnails && inline == "") {
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return exportInfo{}, fmt.Errorf("decode %s: %w", p, err)
+		}
+		if opts.phash {
+			phash = formatPHash(perceptualHash(img))
+		}
+		if opts.extractPalette > 0 {
+			palette = dominantColors(img, opts.extractPalette)
+		}
+		if opts.inlineThumbnails && inline == "" {
+			if inline, err = tinyThumbnailURI(img, opts.inlineMaxBytes); err != nil {
+				return exportInfo{}, fmt.Errorf("inline thumbnail %s: %w", p, err)
 			}
-			return nil
-		})
-		return paths, err
+		}
 	}
 
-	entries, err := os.ReadDir(root)
+	return exportInfo{
+		Name:            base,
+		Width:           cfg.Width,
+		Height:          cfg.Height,
+		Mime:            "image/webp",
+		Thumbnail:       thumbW > 0 && thumbH > 0,
+		ThumbnailWidth:  thumbW,
+		ThumbnailHeight: thumbH,
+		PHash:           phash,
+		Palette:         palette,
+		ThumbnailData:   inline,
+		Quality:         variantQuality(base),
+		DPR:             variantDPR(base),
+		OriginalWidth:   origW,
+		OriginalHeight:  origH,
+	}, nil
+}
+
+// exportKey is the slash-separated path of p relative to --directory; a
+// URL is its own key
+func exportKey(p string) string {
+	if isURL(p) {
+		return p
+	}
+	rel, err := filepath.Rel(opts.directory, p)
 	if err != nil {
-		return nil, err
+		rel = p
 	}
-	for _, e := range entries {
-		if e.IsDir() || isHidden(e.Name()) {
+	return filepath.ToSlash(rel)
+}
+
+// parseExtList parses a comma-separated list like "webp,.avif" into a set of
+// lower-case extensions with a leading dot
+func parseExtList(s string) map[string]struct{} {
+	exts := map[string]struct{}{}
+	for _, part := range strings.Split(s, ",") {
+		ext := strings.ToLower(strings.TrimSpace(part))
+		if ext == "" {
 			continue
 		}
-		ext := strings.ToLower(filepath.Ext(e.Name()))
-		if _, ok := allowed[ext]; ok && ext != ".webp" {
-			paths = append(paths, filepath.Join(root, e.Name()))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
 		}
+		exts[ext] = struct{}{}
 	}
-	return paths, nil
+	return exts
+}
+
+// skipFormatSet returns the extensions --skip-formats leaves untouched,
+// always including .webp unless --include-webp is set
+func skipFormatSet() map[string]struct{} {
+	skip := parseExtList(opts.skipFormats)
+	if !opts.includeWebp {
+		skip[".webp"] = struct{}{}
+	}
+	return skip
+}
+
+// imageExts are the source extensions the registered decoders handle
+var imageExts = map[string]struct{}{
+	".jpg":  {},
+	".jpeg": {},
+	".png":  {},
+	".gif":  {},
+	".bmp":  {},
+	".tif":  {},
+	".tiff": {},
+	".webp": {},
+}
+
+// isImageExt reports whether name has a decodable image extension
+func isImageExt(name string) bool {
+	_, ok := imageExts[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// collectImageFiles returns decodable images in root (optionally recursive),
+// leaving out any extension in skip
+func collectImageFiles(root string, recursive bool, skip map[string]struct{}) ([]string, error) {
+	return walkFiles(root, recursive, func(name string) bool {
+		if _, ok := skip[strings.ToLower(filepath.Ext(name))]; ok {
+			return false
+		}
+		return isImageExt(name)
+	})
 }
 
 // collectWebpFiles returns paths to .webp files in root (optionally recursive)
 func collectWebpFiles(root string, recursive bool) ([]string, error) {
+	return walkFiles(root, recursive, func(name string) bool {
+		return strings.EqualFold(filepath.Ext(name), ".webp")
+	})
+}
+
+// splitBySize separates paths smaller than minBytes from the rest
+func splitBySize(paths []string, minBytes int64) (keep, small []string) {
+	for _, p := range paths {
+		if st, err := os.Stat(p); err == nil && st.Size() < minBytes {
+			small = append(small, p)
+			continue
+		}
+		keep = append(keep, p)
+	}
+	return keep, small
+}
+
+// dirDepth returns how many levels below root dir is (root itself is 0)
+func dirDepth(root, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// walkFiles returns non-hidden files in root (optionally recursive) whose
+// name satisfies keep. Hidden directories are not descended into.
+func walkFiles(root string, recursive bool, keep func(name string) bool) ([]string, error) {
 	var paths []string
 	if recursive {
 		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
@@ -332,21 +1233,29 @@ func collectWebpFiles(root string, recursive bool) ([]string, error) {
 				return err
 			}
 			if d.IsDir() {
+				// Skip hidden directories like .git, .cache, etc.
 				if isHidden(d.Name()) && path != "." {
 					return filepath.SkipDir
 				}
+				if opts.maxDepth >= 0 && dirDepth(root, path) > opts.maxDepth {
+					return filepath.SkipDir
+				}
+				if path != root && isExcludedDir(root, path) {
+					return filepath.SkipDir
+				}
 				return nil
 			}
 			if isHidden(d.Name()) {
 				return nil
 			}
-			if strings.EqualFold(filepath.Ext(d.Name()), ".webp") {
+			if keep(d.Name()) {
 				paths = append(paths, path)
 			}
 			return nil
 		})
 		return paths, err
 	}
+
 	entries, err := os.ReadDir(root)
 	if err != nil {
 		return nil, err
@@ -355,22 +1264,65 @@ func collectWebpFiles(root string, recursive bool) ([]string, error) {
 		if e.IsDir() || isHidden(e.Name()) {
 			continue
 		}
-		if strings.EqualFold(filepath.Ext(e.Name()), ".webp") {
+		if keep(e.Name()) {
 			paths = append(paths, filepath.Join(root, e.Name()))
 		}
 	}
 	return paths, nil
 }
 
+// trimEdgeSet selects which borders trimImage is allowed to remove
+type trimEdgeSet struct {
+	top, right, bottom, left bool
+}
+
+var allTrimEdges = trimEdgeSet{top: true, right: true, bottom: true, left: true}
+
+// parseTrimEdges parses a comma-separated list like "top,bottom" or "all"
+func parseTrimEdges(s string) (trimEdgeSet, error) {
+	var edges trimEdgeSet
+	for _, part := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "all", "":
+			edges = allTrimEdges
+		case "top":
+			edges.top = true
+		case "right":
+			edges.right = true
+		case "bottom":
+			edges.bottom = true
+		case "left":
+			edges.left = true
+		default:
+			return trimEdgeSet{}, fmt.Errorf("invalid trim edge %q (use top, right, bottom, left or all)", part)
+		}
+	}
+	return edges, nil
+}
+
 // trimImage removes transparent borders from an image
 // Similar to Photoshop's Image > Trim functionality
-func trimImage(img image.Image, threshold uint8) image.Image {
+// The returned rectangle is the kept region within the original image; it
+// is empty when the whole image is transparent, in which case the original
+// is returned for the caller to apply --trim-empty-policy. With symmetric,
+// opposite edges lose the same number of pixels.
+func trimImage(img image.Image, threshold uint8, edges trimEdgeSet, minSpeck int, symmetric bool) (image.Image, image.Rectangle) {
 	// Find the bounding box of non-transparent content
-	minX, minY, maxX, maxY := findContentBounds(img, threshold)
+	minX, minY, maxX, maxY := findContentBounds(img, threshold, edges, minSpeck)
 
-	// If no content found or image is already trimmed, return original
+	// If no content found, return original
 	if minX >= maxX || minY >= maxY {
-		return img
+		return img, image.Rectangle{}
+	}
+
+	// Keep the content where it sat relative to the original centre by
+	// widening the tighter side to match the other
+	if symmetric {
+		b := img.Bounds()
+		mx := min(minX-b.Min.X, b.Max.X-maxX)
+		my := min(minY-b.Min.Y, b.Max.Y-maxY)
+		minX, maxX = b.Min.X+mx, b.Max.X-mx
+		minY, maxY = b.Min.Y+my, b.Max.Y-my
 	}
 
 	// Create a new image with the trimmed bounds
@@ -384,12 +1336,94 @@ func trimImage(img image.Image, threshold uint8) image.Image {
 		}
 	}
 
-	return trimmedImg
+	return trimmedImg, image.Rect(minX, minY, maxX, maxY)
+}
+
+// trimSummary aggregates trimImage results across a run
+type trimSummary struct {
+	trimmed   int
+	unchanged int
+	removed   float64 // sum of per-file percent removed
+}
+
+func (t *trimSummary) add(stats convertStats) {
+	if !stats.trimmed {
+		t.unchanged++
+		return
+	}
+	t.trimmed++
+	t.removed += stats.trimRemoved
+}
+
+func (t trimSummary) String() string {
+	avg := 0.0
+	if n := t.trimmed + t.unchanged; n > 0 {
+		avg = t.removed / float64(n)
+	}
+	return fmt.Sprintf("%d trimmed, %d unchanged, average %.1f%% of pixels removed", t.trimmed, t.unchanged, avg)
+}
+
+// trimBounds is the sidecar written by --emit-trim-bounds
+type trimBounds struct {
+	MinX           int `json:"minX"`
+	MinY           int `json:"minY"`
+	MaxX           int `json:"maxX"`
+	MaxY           int `json:"maxY"`
+	OriginalWidth  int `json:"originalWidth"`
+	OriginalHeight int `json:"originalHeight"`
+	TrimmedWidth   int `json:"trimmedWidth"`
+	TrimmedHeight  int `json:"trimmedHeight"`
+}
+
+// writeTrimBounds writes name.trim.json next to the output webp
+func writeTrimBounds(outPath string, orig, kept image.Rectangle) error {
+	tb := trimBounds{
+		MinX:           kept.Min.X,
+		MinY:           kept.Min.Y,
+		MaxX:           kept.Max.X,
+		MaxY:           kept.Max.Y,
+		OriginalWidth:  orig.Dx(),
+		OriginalHeight: orig.Dy(),
+		TrimmedWidth:   kept.Dx(),
+		TrimmedHeight:  kept.Dy(),
+	}
+	data, err := json.MarshalIndent(tb, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(strings.TrimSuffix(outPath, ".webp")+".trim.json", append(data, '\n'))
+}
+
+// writeFileAtomic writes data to a temp file and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
-// findContentBounds finds the bounding box of non-transparent content
-func findContentBounds(img image.Image, threshold uint8) (minX, minY, maxX, maxY int) {
+// findContentBounds finds the bounding box of non-transparent content.
+// Edges not selected in edges are kept at the original image extent.
+// With minSpeck > 0, connected components smaller than minSpeck pixels are
+// ignored.
+func findContentBounds(img image.Image, threshold uint8, edges trimEdgeSet, minSpeck int) (minX, minY, maxX, maxY int) {
 	bounds := img.Bounds()
+	var mask []bool
+	if minSpeck > 0 {
+		mask = contentMask(img, threshold, minSpeck)
+	}
+	isContent := func(x, y int) bool {
+		if mask != nil {
+			return mask[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)]
+		}
+		return !isTransparent(img.At(x, y), threshold)
+	}
 
 	// Initialize bounds to image dimensions
 	minX, minY = bounds.Dx(), bounds.Dy()
@@ -398,7 +1432,7 @@ func findContentBounds(img image.Image, threshold uint8) (minX, minY, maxX, maxY
 	// Scan the image to find content bounds
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if !isTransparent(img.At(x, y), threshold) {
+			if isContent(x, y) {
 				if x < minX {
 					minX = x
 				}
@@ -419,6 +1453,25 @@ func findContentBounds(img image.Image, threshold uint8) (minX, minY, maxX, maxY
 	maxX++
 	maxY++
 
+	// No content found; let the caller keep the original
+	if minX >= maxX || minY >= maxY {
+		return minX, minY, maxX, maxY
+	}
+
+	// Restore edges that should not be trimmed
+	if !edges.top {
+		minY = bounds.Min.Y
+	}
+	if !edges.bottom {
+		maxY = bounds.Max.Y
+	}
+	if !edges.left {
+		minX = bounds.Min.X
+	}
+	if !edges.right {
+		maxX = bounds.Max.X
+	}
+
 	return minX, minY, maxX, maxY
 }
 
@@ -433,142 +1486,949 @@ func isTransparent(c color.Color, threshold uint8) bool {
 	return a8 <= threshold
 }
 
-func convertOne(inputPath string, opts convertOptions) error {
-	in, err := os.Open(inputPath)
-	if err != nil {
-		return err
+// convertStats describes a single conversion for the run summary
+type convertStats struct {
+	format         string   // source format reported by image.Decode
+	downgraded     bool     // lossless output exceeded --lossless-max-bytes and was re-encoded lossy
+	losslessBytes  int64    // size of the discarded lossless encode when downgraded
+	srcBytes       int64    // size of the source file
+	outBytes       int64    // size of the encoded WebP
+	skipReason     string   // why the file was skipped, if known
+	srcWidth       int      // decoded source width
+	srcHeight      int      // decoded source height
+	outWidth       int      // encoded width after trim/resize
+	outHeight      int      // encoded height after trim/resize
+	trimmed        bool     // trimImage removed at least one border
+	resized        bool     // the image was scaled (down, or up by --min-short-side)
+	lossless       bool     // the written encode is lossless
+	quality        float32  // encoder quality used for a lossy encode
+	trimRemoved    float64  // percent of source pixels removed by trimImage
+	keepSource     bool     // the source PNG beat its lossless WebP and stands in for it
+	qualityFloored bool     // an automatic quality was raised to --min-quality
+	outPath        string   // final output path, with --name-template dimensions filled in
+	colorNote      string   // what --force-srgb did with the embedded profile
+	xmpNote        string   // settings taken from the source's XMP hints
+	bpp            float64  // bits per pixel reached by --target-bpp
+	sizeClass      string   // --size-class entry chosen for the source
+	palette        []string // --extract-palette colours, most common first
+	srcHash        string   // SHA-256 of the source, with --hash-db
+	srcSettings    string   // hashSettings the file was converted with, with --hash-db
+	overTarget     bool     // even --min-quality missed the XMP target size
+	dprCapped      []int    // --dpr densities wider than the source
+	subjectCropped bool     // --auto-subject-crop cropped the image
+	outputKept     bool     // skipped because the output already exists; outPath names it
+	srcAlpha       bool     // the decoded source has an alpha channel
+	opaqueAlpha    bool     // srcAlpha, but every output pixel is opaque, so no alpha was stored
+}
+
+// encodeMode describes how a file was encoded, e.g. "lossless" or "lossy q80"
+func encodeMode(stats convertStats) string {
+	if stats.lossless {
+		return "lossless"
+	}
+	return fmt.Sprintf("lossy q%g", stats.quality)
+}
+
+// printSkip reports a skipped file, with the reason when one is known
+func printSkip(path, reason string) {
+	if reason == "" {
+		fmt.Fprintf(runLog, "[SKIP]\t%s\n", path)
+		return
+	}
+	fmt.Fprintf(runLog, "[SKIP]\t%s (%s)\n", path, reason)
+}
+
+// byteTotals accumulates conversion sizes. It is safe for concurrent use so
+// results may be recorded from any goroutine.
+type byteTotals struct {
+	files    atomic.Int64
+	srcBytes atomic.Int64
+	outBytes atomic.Int64
+}
+
+// add records one successful conversion
+func (t *byteTotals) add(stats convertStats) {
+	t.files.Add(1)
+	t.srcBytes.Add(stats.srcBytes)
+	t.outBytes.Add(stats.outBytes)
+}
+
+func (t *byteTotals) String() string {
+	src, out := t.srcBytes.Load(), t.outBytes.Load()
+	saved := 0.0
+	if src > 0 {
+		saved = 100 * float64(src-out) / float64(src)
+	}
+	return fmt.Sprintf("%d file(s), %d bytes in, %d bytes out, saved %.1f%%", t.files.Load(), src, out, saved)
+}
+
+// formatBreakdown renders per-format counts as "jpeg=3, png=2" in a stable order
+func formatBreakdown(formats map[string]int) string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, formats[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func convertOne(inputPath string, opts convertOptions) (convertStats, error) {
+	var stats convertStats
+
+	// Never encode a .webp source over itself
+	outPath := plannedOutPath(inputPath)
+	if outPath == inputPath {
+		stats.skipReason = "output would replace the source"
+		return stats, errSkipped
+	}
+
+	// Known-bad inputs are skipped by content, whatever they are named
+	var srcHash string
+	if len(skipHashes) > 0 {
+		hash, err := hashFile(inputPath)
+		if err != nil {
+			return stats, fmt.Errorf("skip-hashes: %w", err)
+		}
+		if note, ok := skipHashes[hash]; ok {
+			stats.skipReason = skipHashReason(note)
+			return stats, errSkipped
+		}
+		srcHash = hash
+	}
+
+	// Big and small sources get their own encoding with --size-class
+	var classLabel string
+	if len(opts.sizeClasses) > 0 {
+		if st, err := os.Stat(inputPath); err == nil {
+			if c := sizeClassFor(opts.sizeClasses, st.Size()); c != nil {
+				c.apply(&opts)
+				classLabel = c.label
+			}
+		}
 	}
 
-	img, _, err := image.Decode(in)
+	// A quality token in the filename overrides --quality for this file
+	if opts.qualityNameRe != nil {
+		base := filepath.Base(inputPath)
+		if m := opts.qualityNameRe.FindStringSubmatch(strings.TrimSuffix(base, filepath.Ext(base))); m != nil {
+			q, err := strconv.ParseFloat(m[1], 32)
+			if err != nil || q < 0 || q > 100 {
+				return stats, fmt.Errorf("quality token %q in filename must be between 0 and 100", m[1])
+			}
+			opts.quality = float32(q)
+		}
+	}
+
+	// Export hints in the source's XMP override the flags for this file
+	var xmpNote string
+	if opts.xmpQualityField != "" || opts.xmpTargetField != "" {
+		note, err := applyXMPHints(inputPath, &opts)
+		if err != nil {
+			return stats, err
+		}
+		xmpNote = note
+	}
+
+	// Files matching --lossless-include are always lossless
+	for _, pattern := range opts.losslessInclude {
+		if ok, _ := filepath.Match(pattern, filepath.Base(inputPath)); ok {
+			opts.lossless = true
+			break
+		}
+	}
+
+	// Content already converted with these settings needs no work, even
+	// when a restore reset its mtime
+	var srcSettings string
+	if sourceHashes != nil && !opts.previewOnly {
+		if srcHash == "" {
+			hash, err := hashFile(inputPath)
+			if err != nil {
+				return stats, fmt.Errorf("hash-db: %w", err)
+			}
+			srcHash = hash
+		}
+		srcSettings = hashSettings(opts)
+		unchanged, stale := sourceHashes.check(sourceName(inputPath), srcHash, srcSettings)
+		if unchanged {
+			stats.skipReason = "content unchanged since recorded in --hash-db"
+			return stats, errSkipped
+		}
+		// The existing output was made from older content; replace it
+		if stale {
+			opts.overwrite = true
+		}
+	}
+
+	// Leave WebPs that --tag-output recorded at the target settings. The
+	// bytes read for the tag are decoded below rather than read again.
+	var data []byte
+	if opts.recompressThreshold >= 0 && strings.EqualFold(filepath.Ext(inputPath), ".webp") {
+		if data, _ = os.ReadFile(inputPath); data != nil {
+			if q, lossless, ok := readOutputTag(data); ok && lossless == opts.lossless &&
+				(lossless || math.Abs(float64(q-opts.quality)) <= opts.recompressThreshold) {
+				stats.skipReason = fmt.Sprintf("already encoded at quality %g", q)
+				if lossless {
+					stats.skipReason = "already encoded lossless"
+				}
+				return stats, errSkipped
+			}
+		}
+	}
+
+	// Reuse the image decoded by the --previews-first pass when cached
+	src, ok := sourceCache.take(inputPath, opts.previewOnly)
+	if !ok {
+		var decoded decodedSource
+		var err error
+		if data != nil {
+			decoded, err = decodeSourceFrom(inputPath, bytes.NewReader(data), int64(len(data)), opts)
+		} else {
+			decoded, err = decodeSource(inputPath, opts)
+		}
+		if err != nil {
+			return decoded.stats, err
+		}
+		src = decoded
+		sourceCache.put(inputPath, src, opts.previewOnly)
+	}
+	img, anim := src.img, src.anim
+	stats = src.stats
+	stats.xmpNote = xmpNote
+	stats.sizeClass = classLabel
+	if opts.extractPalette > 0 && img != nil {
+		stats.palette = dominantColors(img, opts.extractPalette)
+	}
+	stats.srcHash, stats.srcSettings = srcHash, srcSettings
+	opts.exif = src.exif
+
+	// The preview pass leaves existing outputs and the source alone
+	if opts.previewOnly {
+		if !opts.overwrite && outputExists(outPath, opts) {
+			stats.skipReason = "output exists"
+			return stats, errSkipped
+		}
+		return stats, writeWebp(img, outPath, opts, &stats)
+	}
+
+	// Multi-frame GIFs keep their animation with --anim-format
+	if anim != nil && opts.animFormat == "apng" {
+		outPath = strings.TrimSuffix(outPath, ".webp") + ".png"
+	}
+
+	// A content-addressed name isn't known until the image is encoded
+	if !opts.overwrite && !opts.contentAddressed {
+		if outputExists(outPath, opts) {
+			decision := overwriteSkip
+			if overwritePrompt != nil {
+				decision = overwritePrompt.decide(outPath)
+			}
+			switch decision {
+			case overwriteIfSmaller:
+				opts.onlyIfSmaller = true
+			case overwriteSkip:
+				stats.skipReason = "output exists"
+				if overwritePrompt != nil {
+					stats.skipReason = "output exists, kept at prompt"
+				}
+				// If destination exists and deleteOriginal requested, remove source and skip
+				if opts.deleteOriginal {
+					if err := os.Remove(inputPath); err != nil {
+						return stats, fmt.Errorf("failed to delete original file %s: %w", inputPath, err)
+					}
+					stats.skipReason += ", original deleted"
+					return stats, errSkipped
+				}
+				stats.outPath, stats.outputKept = existingOutput(outPath), true
+				return stats, errSkipped
+			}
+		}
+	}
+
+	var err error
+	if anim != nil {
+		err = writeAnimation(anim, outPath, opts, &stats)
+	} else if len(opts.dprs) > 0 {
+		err = writeDPRSet(img, outPath, opts, &stats)
+	} else {
+		err = writeWebp(img, outPath, opts, &stats)
+	}
 	if err != nil {
-		return fmt.Errorf("decode: %w", err)
-	}
-
-	// Trim the image if requested
-	if opts.trim {
-		img = trimImage(img, opts.trimThreshold)
-	}
-
-	// Resize if max dimensions are set (only scale down, preserve aspect ratio)
-	if opts.maxWidth > 0 || opts.maxHeight > 0 {
-		origBounds := img.Bounds()
-		ow := origBounds.Dx()
-		oh := origBounds.Dy()
-		newW := ow
-		newH := oh
-		if opts.maxWidth > 0 && newW > opts.maxWidth {
-			scale := float64(opts.maxWidth) / float64(newW)
-			newW = opts.maxWidth
-			newH = int(math.Round(float64(newH) * scale))
-		}
-		if opts.maxHeight > 0 && newH > opts.maxHeight {
-			scale := float64(opts.maxHeight) / float64(newH)
-			newH = opts.maxHeight
-			newW = int(math.Round(float64(newW) * scale))
-		}
-		if newW > 0 && newH > 0 && (newW != ow || newH != oh) {
-			dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
-			draw.CatmullRom.Scale(dst, dst.Bounds(), img, origBounds, draw.Over, nil)
-			img = dst
+		// Put the smaller PNG where the WebP would have gone
+		if errors.Is(err, errSkipped) && stats.keepSource && opts.outputDir != "" && !opts.noWrite {
+			if err := copyFile(inputPath, strings.TrimSuffix(stats.outPath, ".webp")+filepath.Ext(inputPath)); err != nil {
+				return stats, writeError(err)
+			}
 		}
+		return stats, err
 	}
 
-	outPath := makeOutPath(inputPath)
-	if !opts.overwrite {
-		if _, statErr := os.Stat(outPath); statErr == nil {
-			// If destination exists and deleteOriginal requested, remove source and skip
-			if opts.deleteOriginal {
-				in.Close()
-				if err := os.Remove(inputPath); err != nil {
-					return fmt.Errorf("failed to delete original file %s: %w", inputPath, err)
+	if opts.deleteOriginal {
+		if err := os.Remove(inputPath); err != nil {
+			return stats, fmt.Errorf("failed to delete original file %s: %w", inputPath, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// writeWebp trims, resizes and encodes a decoded image to outPath,
+// plus any sidecar and thumbnail outputs requested in opts
+func writeWebp(img image.Image, outPath string, opts convertOptions, stats *convertStats) error {
+	// Spend more of the byte budget on the region of interest
+	quality := opts.quality
+	if opts.roi != "" && !opts.lossless && opts.roiQuality > opts.quality {
+		img = applyROI(img, opts.roiSpec.region(img.Bounds()), float64(opts.roiQuality-opts.quality))
+		quality = opts.roiQuality
+	}
+
+	// Trim, --auto-fix and resize run in --pipeline order. Trim bounds are
+	// relative to the image the trim stage receives.
+	srcBounds := img.Bounds()
+	keptBounds := srcBounds
+	for _, stage := range opts.stages {
+		switch stage {
+		case "trim":
+			if opts.trim {
+				srcBounds = img.Bounds()
+				img, keptBounds = trimImage(img, opts.trimThreshold, opts.edges, opts.trimIgnoreSpecks, opts.trimSymmetric)
+				if keptBounds.Empty() {
+					switch opts.trimEmptyPolicy {
+					case "fail":
+						return fmt.Errorf("%w: trim found no content", errBlank)
+					case "1x1":
+						img = image.NewRGBA(image.Rect(0, 0, 1, 1))
+						keptBounds = image.Rectangle{Min: srcBounds.Min, Max: srcBounds.Min.Add(image.Pt(1, 1))}
+					default:
+						keptBounds = srcBounds
+					}
+				}
+				stats.trimmed = keptBounds != srcBounds
+				if area := srcBounds.Dx() * srcBounds.Dy(); area > 0 {
+					stats.trimRemoved = 100 * float64(area-keptBounds.Dx()*keptBounds.Dy()) / float64(area)
+				}
+			}
+			if opts.autoSubjectCrop {
+				b := img.Bounds()
+				var subject image.Rectangle
+				img, subject = cropToSubject(img, opts.subjectThreshold, opts.subjectMargin)
+				stats.subjectCropped = subject != b
+				// Trim bounds and the removed share describe the final crop
+				if opts.trim && stats.subjectCropped {
+					keptBounds = subject.Sub(b.Min).Add(keptBounds.Min)
+					stats.trimmed = true
+					if area := srcBounds.Dx() * srcBounds.Dy(); area > 0 {
+						stats.trimRemoved = 100 * float64(area-keptBounds.Dx()*keptBounds.Dy()) / float64(area)
+					}
+				}
+			}
+		case "aspect":
+			// Conform to --require-aspect when --auto-fix is set
+			if opts.aspect > 0 && opts.autoFix != "" {
+				b := img.Bounds()
+				if !aspectMatches(b.Dx(), b.Dy(), opts.aspect, opts.aspectTolerance) {
+					img = fitAspect(img, opts.aspect, opts.autoFix)
+				}
+			}
+		case "resize":
+			// Upscale to the --min-short-side floor, then scale down to the max
+			// dimensions; the ceilings win when both can't be met. Aspect ratio
+			// is preserved.
+			if opts.maxWidth > 0 || opts.maxHeight > 0 || opts.minShortSide > 0 || opts.boxW > 0 {
+				origBounds := img.Bounds()
+				ow := origBounds.Dx()
+				oh := origBounds.Dy()
+				newW, newH := growToShortSide(ow, oh, opts.minShortSide)
+				if opts.boxW > 0 {
+					newW, newH = fitBox(ow, oh, opts.boxW, opts.boxH)
+				}
+				if opts.maxWidth > 0 && newW > opts.maxWidth {
+					scale := float64(opts.maxWidth) / float64(newW)
+					newW = opts.maxWidth
+					newH = int(math.Round(float64(newH) * scale))
+				}
+				if opts.maxHeight > 0 && newH > opts.maxHeight {
+					scale := float64(opts.maxHeight) / float64(newH)
+					newH = opts.maxHeight
+					newW = int(math.Round(float64(newW) * scale))
+				}
+				if newW > 0 && newH > 0 && (newW != ow || newH != oh) {
+					dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+					scaleInto(dst, img, opts.progressiveDownscale)
+					img = dst
+					stats.resized = true
 				}
-				return errSkipped
 			}
-			return errSkipped
 		}
 	}
 
-	// Ensure output directory exists
-	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+	// Final guardrail, whatever --auto-fix or the resize settings produced
+	if opts.maxOutputDim > 0 {
+		b := img.Bounds()
+		if w, h := clampToDim(b.Dx(), b.Dy(), opts.maxOutputDim); w != b.Dx() || h != b.Dy() {
+			dst := image.NewRGBA(image.Rect(0, 0, w, h))
+			scaleInto(dst, img, opts.progressiveDownscale)
+			img = dst
+			stats.resized = true
+		}
+	}
+	stats.outWidth, stats.outHeight = img.Bounds().Dx(), img.Bounds().Dy()
+	outPath = expandDimensions(outPath, stats.outWidth, stats.outHeight)
+	stats.outPath = outPath
+
+	// Reduce colours after resizing so the palette matches the final pixels
+	if opts.colors > 0 {
+		img = quantizeImage(img, opts.colors, opts.dither)
+	}
+
+	// The preview pass only needs the thumbnail
+	if opts.previewOnly {
+		return writeThumbnail(img, outPath, opts)
+	}
+
+	// libwebp writes no alpha for an all-opaque image; say so per file
+	stats.opaqueAlpha = stats.srcAlpha && isOpaque(img)
+
+	// Stored as is for engines that sample premultiplied textures
+	encImg := img
+	if opts.premultiplyOutput {
+		encImg = premultiplied(img)
+	}
+
+	if len(opts.qualities) > 0 {
+		// One decode/resize, several encodes for side-by-side comparison
+		written := 0
+		var outBytes int64
+		for _, q := range opts.qualities {
+			variant := opts
+			variant.quality = q
+			err := encodeWebp(encImg, qualityVariantPath(outPath, q), q, variant, stats)
+			if errors.Is(err, errSkipped) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			written++
+			outBytes += stats.outBytes
+		}
+		// stats.skipReason holds the last variant's reason
+		if written == 0 {
+			return errSkipped
+		}
+		stats.outBytes = outBytes
+	} else if err := encodeWebp(encImg, outPath, quality, opts, stats); err != nil {
 		return err
 	}
+	// Sidecars and thumbnails follow the hashed name
+	if opts.contentAddressed {
+		outPath = stats.outPath
+	}
+	if opts.noWrite || outPath == stdoutPath {
+		return nil
+	}
 
-	tmpPath := outPath + ".tmp"
-	out, err := os.Create(tmpPath)
+	if opts.trim && opts.emitTrimBounds {
+		if err := writeTrimBounds(outPath, srcBounds, keptBounds); err != nil {
+			return writeError(fmt.Errorf("trim bounds: %w", err))
+		}
+	}
+
+	// The responsive set reuses the decoded, trimmed and resized image
+	if opts.deriveSpec != nil {
+		if err := writeDerivedSet(img, outPath, opts); err != nil {
+			return err
+		}
+	}
+
+	// If thumbnail requested, generate thumbnail from the (possibly resized/trimmed) img.
+	// --previews-first has already written it in the preview pass.
+	if opts.thumbnailPercent > 0 && opts.thumbnailPercent <= 100 && !opts.previewsFirst {
+		return writeThumbnail(img, outPath, opts)
+	}
+
+	return nil
+}
+
+// writeThumbnail writes img scaled by --thumbnail next to outPath as
+// name_thumbnail.webp
+func writeThumbnail(img image.Image, outPath string, opts convertOptions) error {
+	thumbW := int(math.Round(float64(img.Bounds().Dx()) * float64(opts.thumbnailPercent) / 100.0))
+	thumbH := int(math.Round(float64(img.Bounds().Dy()) * float64(opts.thumbnailPercent) / 100.0))
+	if thumbW < 1 {
+		thumbW = 1
+	}
+	if thumbH < 1 {
+		thumbH = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, thumbW, thumbH))
+	scaleInto(dst, img, opts.progressiveDownscale)
+	thumbPath := strings.TrimSuffix(outPath, ".webp") + "_thumbnail.webp"
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return writeError(err)
+	}
+	tmpThumb := thumbPath + ".tmp"
+	thumbFile, err := os.Create(tmpThumb)
 	if err != nil {
-		return err
+		return writeError(err)
+	}
+	thumbOpts := thumbnailOptions(opts)
+	if err := webp.Encode(thumbFile, dst, &webp.Options{Lossless: thumbOpts.lossless, Quality: thumbOpts.quality}); err != nil {
+		thumbFile.Close()
+		os.Remove(tmpThumb)
+		return encodeError("thumbnail webp", err)
 	}
+	if err := thumbFile.Close(); err != nil {
+		os.Remove(tmpThumb)
+		return writeError(err)
+	}
+	if err := os.Rename(tmpThumb, thumbPath); err != nil {
+		os.Remove(tmpThumb)
+		return writeError(err)
+	}
+	return nil
+}
 
-	encOpts := &webp.Options{Lossless: opts.lossless, Quality: opts.quality}
-	if err := webp.Encode(out, img, encOpts); err != nil {
-		out.Close()
-		os.Remove(tmpPath)
-		return fmt.Errorf("encode webp: %w", err)
+// thumbnailOptions returns opts with the encoding thumbnails use: lossy at
+// --thumbnail-quality when it is set, otherwise the image's own settings
+func thumbnailOptions(opts convertOptions) convertOptions {
+	if opts.thumbnailQuality >= 0 {
+		opts.lossless = false
+		opts.quality = opts.thumbnailQuality
+	}
+	return opts
+}
+
+// encodeWebp encodes img at quality and writes it atomically to outPath,
+// applying the size-based fallbacks and skips from opts
+func encodeWebp(img image.Image, outPath string, quality float32, opts convertOptions, stats *convertStats) error {
+	// Automatic quality choices never go below --min-quality
+	if !opts.lossless && quality < opts.minQuality {
+		quality = opts.minQuality
+		stats.qualityFloored = true
+	}
+
+	// Encode into memory first so the size can be checked before writing.
+	// --target-bpp searches for the quality instead; an XMP size goal wins.
+	var buf bytes.Buffer
+	if !opts.lossless && opts.targetBPP > 0 && opts.targetBytes == 0 {
+		fitted, q, bpp, err := encodeToBPP(img, float64(opts.minQuality), opts.targetBPP, opts.bppTolerance)
+		if err != nil {
+			return err
+		}
+		buf = *fitted
+		quality = q
+		stats.bpp = bpp
+	} else {
+		encOpts := &webp.Options{Lossless: opts.lossless, Quality: quality}
+		if err := webp.Encode(&buf, img, encOpts); err != nil {
+			return encodeError("webp", err)
+		}
+	}
+
+	// Step down to the highest quality that fits the XMP size goal
+	if !opts.lossless && opts.targetBytes > 0 && int64(buf.Len()) > opts.targetBytes {
+		fitted, q, err := encodeToTarget(img, opts.minQuality, quality, opts.targetBytes)
+		if err != nil {
+			return err
+		}
+		buf = *fitted
+		quality = q
+		stats.overTarget = int64(buf.Len()) > opts.targetBytes
+	}
+
+	// Fall back to lossy if the lossless output exceeds the size cap
+	if opts.lossless && opts.losslessMaxBytes > 0 && int64(buf.Len()) > opts.losslessMaxBytes {
+		stats.losslessBytes = int64(buf.Len())
+		buf.Reset()
+		lossyQuality := opts.quality
+		if lossyQuality < opts.minQuality {
+			lossyQuality = opts.minQuality
+			stats.qualityFloored = true
+		}
+		if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: lossyQuality}); err != nil {
+			return encodeError("lossy webp", err)
+		}
+		stats.downgraded = true
+		quality = lossyQuality
+	}
+
+	stats.lossless = opts.lossless && !stats.downgraded
+	stats.quality = quality
+
+	if len(opts.exif) > 0 {
+		withExif, err := webp.SetMetadata(buf.Bytes(), opts.exif, "EXIF")
+		if err != nil {
+			return encodeError("exif", err)
+		}
+		buf.Reset()
+		buf.Write(withExif)
+	}
+
+	// Record the settings used for later auditing
+	if opts.tagOutput {
+		tagged, err := tagOutput(buf.Bytes(), *stats)
+		if err != nil {
+			return encodeError("metadata", err)
+		}
+		buf.Reset()
+		buf.Write(tagged)
 	}
-	if err := out.Close(); err != nil {
+	stats.outBytes = int64(buf.Len())
+
+	// Keep the original when the WebP doesn't save enough
+	if opts.minSaving > 0 && stats.srcBytes > 0 {
+		saving := 100 * float64(stats.srcBytes-stats.outBytes) / float64(stats.srcBytes)
+		if saving < opts.minSaving {
+			stats.skipReason = fmt.Sprintf("saving %.1f%% below --min-saving %.1f%%", saving, opts.minSaving)
+			return errSkipped
+		}
+	}
+
+	// Lossless WebP can lose to the PNG it came from on simple graphics
+	if opts.pngIfSmaller && stats.format == "png" && stats.lossless && stats.srcBytes > 0 && stats.outBytes >= stats.srcBytes {
+		stats.keepSource = true
+		stats.skipReason = fmt.Sprintf("lossless WebP is %d bytes, PNG is %d", stats.outBytes, stats.srcBytes)
+		return errSkipped
+	}
+
+	// Keep an existing output that is already at least as small
+	if opts.onlyIfSmaller {
+		if st, err := os.Stat(outPath); err == nil && st.Size() <= stats.outBytes {
+			stats.skipReason = fmt.Sprintf("existing output is %d bytes, new is %d", st.Size(), stats.outBytes)
+			return errSkipped
+		}
+	}
+
+	if opts.contentAddressed && outPath != stdoutPath {
+		outPath = contentAddressedPath(outPath, buf.Bytes())
+		stats.outPath = outPath
+		// The same name means the same bytes are already there
+		if _, err := os.Stat(outPath); err == nil {
+			return nil
+		}
+	}
+
+	if opts.noWrite {
+		return nil
+	}
+
+	if outPath == stdoutPath {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			return writeError(err)
+		}
+		return nil
+	}
+
+	// Ensure output directory exists
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return writeError(err)
+	}
+
+	tmpPath := outPath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o644); err != nil {
 		os.Remove(tmpPath)
-		return err
+		return writeError(err)
 	}
 
 	if err := os.Rename(tmpPath, outPath); err != nil {
 		os.Remove(tmpPath)
-		return err
+		return writeError(err)
 	}
 
-	in.Close()
-
-	// If thumbnail requested, generate thumbnail from the (possibly resized/trimmed) img
-	if opts.thumbnailPercent > 0 && opts.thumbnailPercent <= 100 {
-		thumbW := int(math.Round(float64(img.Bounds().Dx()) * float64(opts.thumbnailPercent) / 100.0))
-		thumbH := int(math.Round(float64(img.Bounds().Dy()) * float64(opts.thumbnailPercent) / 100.0))
-		if thumbW < 1 {
-			thumbW = 1
+	// Read the file back so both encoder bugs and bad writes are caught; a
+	// mismatching output is not left behind
+	if opts.verifyRoundtrip {
+		if err := verifyRoundtrip(outPath, img, opts.roundtripTolerance); err != nil {
+			os.Remove(outPath)
+			return err
 		}
-		if thumbH < 1 {
-			thumbH = 1
+	}
+
+	return nil
+}
+
+// encodeToTarget binary-searches whole qualities between lo and hi for the
+// highest whose lossy encode is at most target bytes. When none fits, the
+// encode at lo is returned.
+func encodeToTarget(img image.Image, lo, hi float32, target int64) (*bytes.Buffer, float32, error) {
+	encode := func(q float32) (*bytes.Buffer, error) {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, img, &webp.Options{Quality: q}); err != nil {
+			return nil, encodeError("webp", err)
 		}
-		dst := image.NewRGBA(image.Rect(0, 0, thumbW, thumbH))
-		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
-		thumbPath := strings.TrimSuffix(outPath, ".webp") + "_thumbnail.webp"
-		tmpThumb := thumbPath + ".tmp"
-		thumbFile, err := os.Create(tmpThumb)
+		return &buf, nil
+	}
+	best, err := encode(lo)
+	if err != nil {
+		return nil, 0, err
+	}
+	bestQ := lo
+	if int64(best.Len()) > target {
+		return best, lo, nil
+	}
+	low, high := int(math.Ceil(float64(lo)))+1, int(hi)-1
+	for low <= high {
+		mid := (low + high) / 2
+		buf, err := encode(float32(mid))
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		if err := webp.Encode(thumbFile, dst, &webp.Options{Lossless: opts.lossless, Quality: opts.quality}); err != nil {
-			thumbFile.Close()
-			os.Remove(tmpThumb)
-			return fmt.Errorf("encode thumbnail webp: %w", err)
+		if int64(buf.Len()) <= target {
+			best, bestQ = buf, float32(mid)
+			low = mid + 1
+		} else {
+			high = mid - 1
 		}
-		if err := thumbFile.Close(); err != nil {
-			os.Remove(tmpThumb)
-			return err
+	}
+	return best, bestQ, nil
+}
+
+// encodeToBPP binary-searches whole qualities from lo to 100 for a lossy
+// encode within tolerance (a fraction) of target bits per pixel, returning
+// the first one found or else the closest tried, with its bits per pixel
+func encodeToBPP(img image.Image, lo, target, tolerance float64) (*bytes.Buffer, float32, float64, error) {
+	pixels := float64(img.Bounds().Dx() * img.Bounds().Dy())
+	var best *bytes.Buffer
+	var bestQ float32
+	bestBPP := math.Inf(1)
+	low, high := int(math.Ceil(lo)), 100
+	for low <= high {
+		mid := (low + high) / 2
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, img, &webp.Options{Quality: float32(mid)}); err != nil {
+			return nil, 0, 0, encodeError("webp", err)
 		}
-		if err := os.Rename(tmpThumb, thumbPath); err != nil {
-			os.Remove(tmpThumb)
-			return err
+		bpp := float64(buf.Len()) * 8 / pixels
+		if math.Abs(bpp-target) < math.Abs(bestBPP-target) {
+			best, bestQ, bestBPP = &buf, float32(mid), bpp
+		}
+		if math.Abs(bpp-target) <= tolerance*target {
+			break
+		}
+		if bpp > target {
+			high = mid - 1
+		} else {
+			low = mid + 1
 		}
 	}
+	return best, bestQ, bestBPP, nil
+}
 
-	if opts.deleteOriginal {
-		if err := os.Remove(inputPath); err != nil {
-			return fmt.Errorf("failed to delete original file %s: %w", inputPath, err)
+// parseQualities parses "80,60" into distinct values within 0-100
+func parseQualities(s string) ([]float32, error) {
+	var out []float32
+	seen := map[float32]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(part, 32)
+		if err != nil || q < 0 || q > 100 {
+			return nil, fmt.Errorf("invalid quality %q in --qualities (use 0-100)", part)
+		}
+		if !seen[float32(q)] {
+			seen[float32(q)] = true
+			out = append(out, float32(q))
 		}
 	}
+	return out, nil
+}
 
-	return nil
+// qualityVariantPath turns name.webp into name.q80.webp
+func qualityVariantPath(outPath string, quality float32) string {
+	return strings.TrimSuffix(outPath, ".webp") + ".q" + strconv.FormatFloat(float64(quality), 'f', -1, 32) + ".webp"
+}
+
+// qualityVariantPattern matches names written by --qualities
+var qualityVariantPattern = regexp.MustCompile(`(?i)\.q(\d+(?:\.\d+)?)\.webp$`)
+
+// variantQuality returns the quality encoded in a --qualities output name, or 0
+func variantQuality(name string) float64 {
+	m := qualityVariantPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0
+	}
+	q, _ := strconv.ParseFloat(m[1], 64)
+	return q
+}
+
+// outputExists reports whether every output for outPath is already present
+func outputExists(outPath string, opts convertOptions) bool {
+	// Before decoding, any size satisfies a {width}/{height} template
+	if hasDimensionPlaceholder(outPath) {
+		pattern := expandDimensions(outPath, -1, -1)
+		if len(opts.qualities) > 0 {
+			pattern = qualityVariantPath(pattern, opts.qualities[0])
+		}
+		matches, _ := filepath.Glob(pattern)
+		return len(matches) > 0
+	}
+	paths := []string{outPath}
+	if len(opts.qualities) > 0 {
+		paths = paths[:0]
+		for _, q := range opts.qualities {
+			paths = append(paths, qualityVariantPath(outPath, q))
+		}
+	}
+	if len(opts.dprs) > 0 {
+		paths = paths[:0]
+		for _, d := range opts.dprs {
+			paths = append(paths, dprVariantPath(outPath, d))
+		}
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// existingOutput returns the file outputExists found for outPath: the
+// first match of a {width}/{height} template, or outPath itself
+func existingOutput(outPath string) string {
+	if hasDimensionPlaceholder(outPath) {
+		if matches, _ := filepath.Glob(expandDimensions(outPath, -1, -1)); len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return outPath
+}
+
+// expandDimensions fills {width} and {height} in a --name-template output
+// path; negative sizes expand to a glob wildcard
+func expandDimensions(p string, w, h int) string {
+	ws, hs := "*", "*"
+	if w >= 0 {
+		ws, hs = strconv.Itoa(w), strconv.Itoa(h)
+	}
+	return strings.NewReplacer("{width}", ws, "{height}", hs).Replace(p)
+}
+
+// hasDimensionPlaceholder reports whether p still needs expandDimensions
+func hasDimensionPlaceholder(p string) bool {
+	return strings.Contains(p, "{width}") || strings.Contains(p, "{height}")
+}
+
+// outputStem drops the extension from a file name: only the last one, so
+// photo.final.jpg keeps photo.final, or with --strip-all-extensions
+// everything from the first dot after any leading dots
+func outputStem(base string) string {
+	if opts.stripAllExtensions {
+		lead := len(base) - len(strings.TrimLeft(base, "."))
+		if i := strings.Index(base[lead:], "."); i > 0 {
+			return base[:lead+i]
+		}
+		return base
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base))
 }
 
 func makeOutPath(input string) string {
-	dir := filepath.Dir(input)
-	base := filepath.Base(input)
-	name := strings.TrimSuffix(base, filepath.Ext(base))
+	dir := mirrorDir(filepath.Dir(input))
+	if _, ok := urlSources[input]; ok {
+		dir = outputRoot()
+	}
+	name := outputStem(filepath.Base(input))
+	if opts.qualityNameRe != nil {
+		name = opts.qualityNameRe.ReplaceAllString(name, "")
+	}
+	// {width} and {height} stay until writeWebp knows the final size
+	if opts.nameTemplate != "" {
+		name = strings.ReplaceAll(opts.nameTemplate, "{name}", name)
+	}
 	if opts.thumbnailPercent > 0 {
 		return filepath.Join(dir, fmt.Sprintf("%s_thumbnail.webp", name))
 	}
 	return filepath.Join(dir, name+".webp")
 }
 
+// expandOutputDir substitutes run settings into an --output-dir template,
+// e.g. "out/q{quality}" becomes "out/q80"
+func expandOutputDir(dir string, opts convertOptions) string {
+	mode := "lossy"
+	if opts.lossless {
+		mode = "lossless"
+	}
+	return strings.NewReplacer(
+		"{quality}", strconv.FormatFloat(float64(opts.quality), 'f', -1, 32),
+		"{format}", "webp",
+		"{mode}", mode,
+	).Replace(dir)
+}
+
+// outputRoot is the directory outputs are written under
+func outputRoot() string {
+	if opts.outputDir != "" {
+		return opts.outputDir
+	}
+	return opts.directory
+}
+
+// sameDir reports whether a and b name the same directory
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// checkWritable verifies files can be created in dir (creating dir if needed)
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("output directory %s cannot be created: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".image-convert-*")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable: %w", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return nil
+}
+
+// mirrorDir maps a source directory to its counterpart under --output-dir,
+// or returns it unchanged when no output directory is set
+func mirrorDir(dir string) string {
+	if opts.outputDir == "" {
+		return dir
+	}
+	rel, err := filepath.Rel(opts.directory, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+	return filepath.Join(opts.outputDir, rel)
+}
+
+// isExcludedDir reports whether dir matches an --exclude-dir entry, either
+// by base name or by slash-separated path relative to root
+func isExcludedDir(root, dir string) bool {
+	if len(opts.excludeDirs) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		rel = dir
+	}
+	rel = filepath.ToSlash(rel)
+	for _, ex := range opts.excludeDirs {
+		ex = strings.Trim(filepath.ToSlash(ex), "/")
+		if ex == filepath.Base(dir) || ex == rel {
+			return true
+		}
+	}
+	return false
+}
+
 func isHidden(name string) bool {
 	return strings.HasPrefix(name, ".")
 }
@@ -586,13 +2446,32 @@ func generateThumbnailsForWebps(root string, recursive bool, opts convertOptions
 				continue
 			}
 		}
-		f, err := os.Open(p)
+		data, err := os.ReadFile(p)
 		if err != nil {
 			return err
 		}
-		img, err := webp.Decode(f)
-		f.Close()
-		if err != nil {
+		decodeCounts.add(p)
+		var img image.Image
+		note := ""
+		// webp.Decode would silently keep only the first frame
+		if isAnimatedWebP(data) {
+			switch opts.animatedThumbnails {
+			case "skip":
+				printSkip(p, "animated source, --animated-thumbnails skip")
+				continue
+			case "animated":
+				if err := writeAnimatedThumbnail(data, thumbPath, thumbnailOptions(opts)); err != nil {
+					return fmt.Errorf("animated thumbnail %s: %w", p, err)
+				}
+				fmt.Fprintf(runLog, "[THUMB]\t%s (animated)\n", thumbPath)
+				continue
+			}
+			frames, err := decodeWebPFrames(data)
+			if err != nil {
+				return fmt.Errorf("decode webp %s: %w", p, err)
+			}
+			img, note = frames[0], " (first frame of animated source)"
+		} else if img, err = webp.Decode(bytes.NewReader(data)); err != nil {
 			return fmt.Errorf("decode webp %s: %w", p, err)
 		}
 		thumbW := int(math.Round(float64(img.Bounds().Dx()) * float64(opts.thumbnailPercent) / 100.0))
@@ -604,13 +2483,14 @@ func generateThumbnailsForWebps(root string, recursive bool, opts convertOptions
 			thumbH = 1
 		}
 		dst := image.NewRGBA(image.Rect(0, 0, thumbW, thumbH))
-		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+		scaleInto(dst, img, opts.progressiveDownscale)
 		tmp := thumbPath + ".tmp"
 		out, err := os.Create(tmp)
 		if err != nil {
 			return err
 		}
-		if err := webp.Encode(out, dst, &webp.Options{Lossless: opts.lossless, Quality: opts.quality}); err != nil {
+		thumbOpts := thumbnailOptions(opts)
+		if err := webp.Encode(out, dst, &webp.Options{Lossless: thumbOpts.lossless, Quality: thumbOpts.quality}); err != nil {
 			out.Close()
 			os.Remove(tmp)
 			return fmt.Errorf("encode thumbnail webp: %w", err)
@@ -623,7 +2503,7 @@ func generateThumbnailsForWebps(root string, recursive bool, opts convertOptions
 			os.Remove(tmp)
 			return err
 		}
-		fmt.Printf("[THUMB]\t%s\n", thumbPath)
+		fmt.Fprintf(runLog, "[THUMB]\t%s%s\n", thumbPath, note)
 	}
 	return nil
 }
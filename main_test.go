@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	webp "github.com/chai2010/webp"
+)
+
+func TestByteTotalsConcurrentAdd(t *testing.T) {
+	const workers, perWorker = 8, 1000
+	var totals byteTotals
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				totals.add(convertStats{srcBytes: 3, outBytes: 2})
+			}
+		}()
+	}
+	wg.Wait()
+
+	const n = workers * perWorker
+	if got := totals.files.Load(); got != n {
+		t.Errorf("files = %d, want %d", got, n)
+	}
+	if got := totals.srcBytes.Load(); got != 3*n {
+		t.Errorf("srcBytes = %d, want %d", got, 3*n)
+	}
+	if got := totals.outBytes.Load(); got != 2*n {
+		t.Errorf("outBytes = %d, want %d", got, 2*n)
+	}
+}
+
+func TestOutputStem(t *testing.T) {
+	tests := []struct {
+		base     string
+		stripAll bool
+		want     string
+	}{
+		{"photo.jpg", false, "photo"},
+		{"photo.final.jpg", false, "photo.final"},
+		{"archive.2024.01.02.png", false, "archive.2024.01.02"},
+		{"noext", false, "noext"},
+		{".hidden.png", false, ".hidden"},
+		{"photo.jpg", true, "photo"},
+		{"photo.final.jpg", true, "photo"},
+		{"archive.2024.01.02.png", true, "archive"},
+		{"noext", true, "noext"},
+		{".hidden.final.png", true, ".hidden"},
+		{"..two.leading.png", true, "..two"},
+	}
+	saved := opts.stripAllExtensions
+	t.Cleanup(func() { opts.stripAllExtensions = saved })
+	for _, tt := range tests {
+		opts.stripAllExtensions = tt.stripAll
+		if got := outputStem(tt.base); got != tt.want {
+			t.Errorf("outputStem(%q) with stripAll=%t = %q, want %q", tt.base, tt.stripAll, got, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateDimensions(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	img := image.NewNRGBA(image.Rect(0, 0, 90, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 90; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts.directory = dir
+	opts.nameTemplate = "{name}-{width}x{height}"
+
+	o := opts
+	o.quality = 80
+	o.maxWidth = 45
+	o.stages = pipelineStages
+	stats, err := convertOne(src, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w, h int
+	if _, err := fmt.Sscanf(filepath.Base(stats.outPath), "photo-%dx%d.webp", &w, &h); err != nil {
+		t.Fatalf("output %s does not follow the template: %v", stats.outPath, err)
+	}
+	out, err := os.Open(stats.outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cfg, err := webp.DecodeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != cfg.Width || h != cfg.Height {
+		t.Errorf("name says %dx%d, file is %dx%d", w, h, cfg.Width, cfg.Height)
+	}
+	if cfg.Width != 45 || cfg.Height != 20 {
+		t.Errorf("file is %dx%d, want 45x20", cfg.Width, cfg.Height)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// parseHexColor parses "#rrggbb" or "rrggbb"
+func parseHexColor(s string) (color.NRGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// applyMatte softens the 1-bit transparency of a paletted (GIF) image.
+// Alpha becomes the opaque coverage of each pixel's 3x3 neighbourhood and
+// transparent pixels take the matte colour, so edges fade into the matte
+// instead of stepping. Images without a transparent palette entry are
+// returned unchanged.
+func applyMatte(img image.Image, matte color.NRGBA) image.Image {
+	p, ok := img.(*image.Paletted)
+	if !ok || !hasTransparentEntry(p.Palette) {
+		return img
+	}
+	b := p.Bounds()
+	opaque := func(x, y int) bool {
+		if !(image.Point{x, y}.In(b)) {
+			return false
+		}
+		_, _, _, a := p.At(x, y).RGBA()
+		return a != 0
+	}
+
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			covered := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if opaque(x+dx, y+dy) {
+						covered++
+					}
+				}
+			}
+			c := matte
+			if opaque(x, y) {
+				c = color.NRGBAModel.Convert(p.At(x, y)).(color.NRGBA)
+			}
+			c.A = uint8(covered * 255 / 9)
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
+
+// hasTransparentEntry reports whether the palette has a fully transparent colour
+func hasTransparentEntry(pal color.Palette) bool {
+	for _, c := range pal {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return true
+		}
+	}
+	return false
+}
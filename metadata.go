@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	webp "github.com/chai2010/webp"
+)
+
+// version is the tool version recorded by --tag-output; override at build
+// time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// outputTagXMP returns an XMP packet recording the encode settings and the
+// source dimensions before trim and resize
+func outputTagXMP(quality float32, lossless bool, srcWidth, srcHeight int) []byte {
+	return []byte(fmt.Sprintf(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>`+
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/">`+
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`+
+		`<rdf:Description rdf:about="" xmlns:imageconvert="https://github.com/mettlestate/image-convert/ns/1.0/"`+
+		` imageconvert:quality=%s imageconvert:lossless=%s imageconvert:version=%s`+
+		` imageconvert:originalWidth="%d" imageconvert:originalHeight="%d"/>`+
+		`</rdf:RDF></x:xmpmeta><?xpacket end="w"?>`,
+		strconv.Quote(strconv.FormatFloat(float64(quality), 'f', -1, 32)),
+		strconv.Quote(strconv.FormatBool(lossless)),
+		strconv.Quote(version),
+		srcWidth, srcHeight))
+}
+
+// tagOutput embeds the settings XMP for stats into encoded WebP data
+func tagOutput(data []byte, stats convertStats) ([]byte, error) {
+	return webp.SetMetadata(data, outputTagXMP(stats.quality, stats.lossless, stats.srcWidth, stats.srcHeight), "XMP")
+}
+
+var (
+	tagQualityRe  = regexp.MustCompile(`imageconvert:quality="([^"]*)"`)
+	tagLosslessRe = regexp.MustCompile(`imageconvert:lossless="([^"]*)"`)
+	tagOrigSizeRe = regexp.MustCompile(`imageconvert:originalWidth="(\d+)" imageconvert:originalHeight="(\d+)"`)
+)
+
+// readOriginalSize returns the source dimensions recorded by tagOutput in
+// WebP data. ok is false for untagged files and tags written before the
+// dimensions were recorded.
+func readOriginalSize(data []byte) (width, height int, ok bool) {
+	xmp, err := webp.GetMetadata(data, "XMP")
+	if err != nil {
+		return 0, 0, false
+	}
+	m := tagOrigSizeRe.FindSubmatch(xmp)
+	if m == nil {
+		return 0, 0, false
+	}
+	width, _ = strconv.Atoi(string(m[1]))
+	height, _ = strconv.Atoi(string(m[2]))
+	return width, height, true
+}
+
+// readOutputTag returns the settings recorded by tagOutput in WebP data.
+// ok is false when the file carries no such tag.
+func readOutputTag(data []byte) (quality float32, lossless bool, ok bool) {
+	xmp, err := webp.GetMetadata(data, "XMP")
+	if err != nil || len(xmp) == 0 {
+		return 0, false, false
+	}
+	qm := tagQualityRe.FindSubmatch(xmp)
+	lm := tagLosslessRe.FindSubmatch(xmp)
+	if qm == nil || lm == nil {
+		return 0, false, false
+	}
+	q, err := strconv.ParseFloat(string(qm[1]), 32)
+	if err != nil {
+		return 0, false, false
+	}
+	l, err := strconv.ParseBool(string(lm[1]))
+	if err != nil {
+		return 0, false, false
+	}
+	return float32(q), l, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortOrders are the accepted --sort-by values
+var sortOrders = []string{"name", "newest", "oldest", "largest", "smallest"}
+
+// sortFiles reorders paths in place for --sort-by. "name" keeps the walk
+// order, which is already lexical. Files that can't be stat'ed sort last.
+func sortFiles(paths []string, by string) error {
+	if by == "" || by == "name" {
+		return nil
+	}
+	type entry struct {
+		path string
+		mod  time.Time
+		size int64
+		ok   bool
+	}
+	entries := make([]entry, len(paths))
+	for i, p := range paths {
+		entries[i].path = p
+		if st, err := os.Stat(p); err == nil {
+			entries[i] = entry{path: p, mod: st.ModTime(), size: st.Size(), ok: true}
+		}
+	}
+
+	var less func(a, b entry) bool
+	switch by {
+	case "newest":
+		less = func(a, b entry) bool { return a.mod.After(b.mod) }
+	case "oldest":
+		less = func(a, b entry) bool { return a.mod.Before(b.mod) }
+	case "largest":
+		less = func(a, b entry) bool { return a.size > b.size }
+	case "smallest":
+		less = func(a, b entry) bool { return a.size < b.size }
+	default:
+		return fmt.Errorf("sort-by must be one of %s", strings.Join(sortOrders, ", "))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		return less(a, b)
+	})
+	for i, e := range entries {
+		paths[i] = e.path
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// paletteSampleWidth is the width images are reduced to before
+// --extract-palette clusters their colours; dominance survives the
+// downscale and the median cut stays fast on large photos
+const paletteSampleWidth = 64
+
+// dominantColors returns up to n colours of img as "#rrggbb", most common
+// first. Mostly transparent colours are left out, since they say nothing
+// about what the image looks like.
+func dominantColors(img image.Image, n int) []string {
+	if img.Bounds().Dx() > paletteSampleWidth {
+		img = scaledToWidth(img, paletteSampleWidth, true)
+	}
+	boxes := medianCutBoxes(img, n)
+	sort.SliceStable(boxes, func(i, j int) bool { return len(boxes[i]) > len(boxes[j]) })
+
+	out := []string{}
+	seen := map[string]bool{}
+	for _, box := range boxes {
+		c := boxAverage(box)
+		if c.A < 128 {
+			continue
+		}
+		// Samples are premultiplied; undo it for the displayed colour
+		r, g, b := int(c.R)*255/int(c.A), int(c.G)*255/int(c.A), int(c.B)*255/int(c.A)
+		hex := fmt.Sprintf("#%02x%02x%02x", min(r, 255), min(g, 255), min(b, 255))
+		if !seen[hex] {
+			seen[hex] = true
+			out = append(out, hex)
+		}
+	}
+	return out
+}
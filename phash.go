@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// phashSize is the side of the grayscale downscale fed to the DCT
+const phashSize = 32
+
+// perceptualHash returns a 64-bit DCT-based perceptual hash of img.
+// The image is reduced to a 32x32 grayscale, transformed with a 2D DCT-II,
+// and the lowest 8x8 frequencies (excluding DC) are compared against their
+// median. Visually similar images yield hashes with a small Hamming distance.
+func perceptualHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, phashSize, phashSize))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var pixels [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			pixels[y][x] = float64(small.GrayAt(x, y).Y)
+		}
+	}
+
+	// Separable 2D DCT-II, keeping only the 8x8 low-frequency corner
+	var cosTable [8][phashSize]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < phashSize; x++ {
+			cosTable[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	var rows [phashSize][8]float64
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < phashSize; x++ {
+				sum += pixels[y][x] * cosTable[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y][u] * cosTable[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// Median of the AC coefficients; DC only carries overall brightness
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coeffs {
+		if i > 0 && c > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return hash
+}
+
+// formatPHash renders a hash as 16 hex digits
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
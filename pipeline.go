@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pipelineStages are the reorderable transform stages of writeWebp, in
+// their default order. Each still runs only when its own flags ask for it
+// (--trim or --auto-subject-crop, --auto-fix, --width/--height).
+var pipelineStages = []string{"trim", "aspect", "resize"}
+
+// parsePipeline parses a --pipeline list such as "resize,trim,aspect". Every
+// stage must appear exactly once so no transform is dropped by accident.
+func parsePipeline(s string) ([]string, error) {
+	var stages []string
+	for _, part := range strings.Split(s, ",") {
+		stage := strings.ToLower(strings.TrimSpace(part))
+		if !slices.Contains(pipelineStages, stage) {
+			return nil, fmt.Errorf("invalid pipeline stage %q (use %s)", part, strings.Join(pipelineStages, ", "))
+		}
+		if slices.Contains(stages, stage) {
+			return nil, fmt.Errorf("pipeline stage %q listed twice", stage)
+		}
+		stages = append(stages, stage)
+	}
+	if len(stages) != len(pipelineStages) {
+		return nil, fmt.Errorf("pipeline must list every stage once: %s", strings.Join(pipelineStages, ", "))
+	}
+	return stages, nil
+}
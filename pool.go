@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// convertResult is the outcome of one queued conversion
+type convertResult struct {
+	path  string
+	stats convertStats
+	err   error
+}
+
+// converterPool is a fixed set of workers fed incrementally through Enqueue.
+// It runs until Close is called, so callers can keep one pool alive and
+// submit paths as they arrive instead of building a file list up front.
+// Results must be drained concurrently with Enqueue.
+type converterPool struct {
+	jobs     chan string
+	results  chan convertResult
+	wg       sync.WaitGroup
+	once     sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu       sync.Mutex
+	inFlight map[int]inFlightJob // worker index -> current job
+}
+
+// inFlightJob is the file a worker is currently converting
+type inFlightJob struct {
+	path  string
+	start time.Time
+}
+
+// newConverterPool starts workers goroutines converting with opts
+func newConverterPool(workers int, opts convertOptions) *converterPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &converterPool{
+		jobs:     make(chan string),
+		results:  make(chan convertResult),
+		stop:     make(chan struct{}),
+		inFlight: map[int]inFlightJob{},
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func(id int) {
+			defer p.wg.Done()
+			for path := range p.jobs {
+				p.setInFlight(id, path)
+				stats, err := convertOne(path, optionsFor(path, opts))
+				p.setInFlight(id, "")
+				p.results <- convertResult{path: path, stats: stats, err: err}
+			}
+		}(i)
+	}
+	return p
+}
+
+// setInFlight records (or clears, for an empty path) a worker's current job
+func (p *converterPool) setInFlight(id int, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if path == "" {
+		delete(p.inFlight, id)
+		return
+	}
+	p.inFlight[id] = inFlightJob{path: path, start: time.Now()}
+}
+
+// InFlight describes the files workers are converting right now, oldest first
+func (p *converterPool) InFlight() []string {
+	p.mu.Lock()
+	jobs := make([]inFlightJob, 0, len(p.inFlight))
+	for _, j := range p.inFlight {
+		jobs = append(jobs, j)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].start.Before(jobs[j].start) })
+	out := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, fmt.Sprintf("%s (%s)", j.path, time.Since(j.start).Round(time.Second)))
+	}
+	return out
+}
+
+// minStallTimeout is the shortest --stall-timeout; the watchdog ticks at
+// half the interval and reports idle time in whole seconds
+const minStallTimeout = time.Second
+
+// stallWatchdog logs the in-flight files whenever no result has arrived for
+// interval. Call Progress for each result and Stop when the run ends.
+type stallWatchdog struct {
+	mu   sync.Mutex
+	last time.Time
+	done chan struct{}
+}
+
+func newStallWatchdog(pool *converterPool, interval time.Duration) *stallWatchdog {
+	w := &stallWatchdog{last: time.Now(), done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				idle := time.Since(w.last)
+				if idle >= interval {
+					// Warn once per interval rather than on every tick
+					w.last = time.Now()
+				}
+				w.mu.Unlock()
+				if idle >= interval {
+					fmt.Fprintf(os.Stderr, "[STALL]\tno results for %s; in flight: %s\n",
+						idle.Round(time.Second), strings.Join(pool.InFlight(), ", "))
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// Progress records that a result arrived
+func (w *stallWatchdog) Progress() {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+}
+
+// Stop ends the watchdog goroutine
+func (w *stallWatchdog) Stop() {
+	close(w.done)
+}
+
+// Enqueue submits a path for conversion, blocking until a worker takes it.
+// It returns false without queueing once Stop has been called. It must not
+// be called after Close.
+func (p *converterPool) Enqueue(path string) bool {
+	select {
+	case <-p.stop:
+		return false
+	default:
+	}
+	select {
+	case p.jobs <- path:
+		return true
+	case <-p.stop:
+		return false
+	}
+}
+
+// Stop makes further Enqueue calls return false; jobs already taken by a
+// worker still finish and are delivered on Results
+func (p *converterPool) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// Results returns the channel of finished conversions. It is closed once
+// Close has been called and every queued job has finished.
+func (p *converterPool) Results() <-chan convertResult {
+	return p.results
+}
+
+// Close stops accepting jobs; Results is closed after in-flight work drains
+func (p *converterPool) Close() {
+	p.once.Do(func() {
+		close(p.jobs)
+		go func() {
+			p.wg.Wait()
+			close(p.results)
+		}()
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// presets are named option bundles selectable with --preset.
+// Only the fields listed in presetFlags are taken from a preset.
+var presets = map[string]convertOptions{
+	"web":     {quality: 82, maxWidth: 1920, maxHeight: 1920},
+	"thumb":   {quality: 70, maxWidth: 400, maxHeight: 400},
+	"archive": {quality: 100, lossless: true},
+	"email":   {quality: 70, maxWidth: 800, maxHeight: 800},
+}
+
+// presetFlags maps flag names to a setter copying that field from a preset
+var presetFlags = map[string]func(dst *convertOptions, p convertOptions){
+	"quality":  func(dst *convertOptions, p convertOptions) { dst.quality = p.quality },
+	"lossless": func(dst *convertOptions, p convertOptions) { dst.lossless = p.lossless },
+	"width":    func(dst *convertOptions, p convertOptions) { dst.maxWidth = p.maxWidth },
+	"height":   func(dst *convertOptions, p convertOptions) { dst.maxHeight = p.maxHeight },
+}
+
+// presetNames returns the preset names in sorted order
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset copies preset values into dst for every flag the user didn't set
+func applyPreset(name string, dst *convertOptions, flags *pflag.FlagSet) error {
+	p, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown preset %q (use %s)", name, strings.Join(presetNames(), ", "))
+	}
+	for flag, set := range presetFlags {
+		if !flags.Changed(flag) {
+			set(dst, p)
+		}
+	}
+	return nil
+}
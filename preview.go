@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+)
+
+// runPreviews writes the thumbnail for every file before any full-size
+// output, so a gallery can show placeholders while the rest converts.
+// Failures are left for the full pass to report.
+func runPreviews(files []string) {
+	previewOpts := opts
+	previewOpts.previewOnly = true
+	pool := newConverterPool(previewOpts.workers, previewOpts)
+	go func() {
+		for _, f := range files {
+			pool.Enqueue(f)
+		}
+		pool.Close()
+	}()
+
+	written := 0
+	for r := range pool.Results() {
+		if r.err == nil {
+			written++
+			fmt.Fprintf(runLog, "[PREVIEW]\t%s\n", r.path)
+		}
+	}
+	fmt.Fprintf(runLog, "Previews: %d of %d written\n", written, len(files))
+}
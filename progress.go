@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"time"
+)
+
+// etaTracker estimates remaining time from completed work. Files are weighted
+// by pixel count (from image.DecodeConfig) since a 40MP TIFF takes far longer
+// than an icon; throughput is a moving average so the estimate follows
+// changes in speed during the run.
+type etaTracker struct {
+	weights     map[string]float64
+	total       int
+	done        int
+	totalWeight float64
+	doneWeight  float64
+	start       time.Time
+	last        time.Time
+	rate        float64 // smoothed weight per second
+	interval    time.Duration
+	lastReport  time.Time
+}
+
+// etaSmoothing is the weight of the newest sample in the moving average
+const etaSmoothing = 0.2
+
+func newETATracker(files []string) *etaTracker {
+	t := &etaTracker{weights: make(map[string]float64, len(files)), total: len(files)}
+	var unknown []string
+	for _, p := range files {
+		if w := pixelWeight(p); w > 0 {
+			t.weights[p] = w
+			t.totalWeight += w
+			continue
+		}
+		unknown = append(unknown, p)
+	}
+	// Files whose header can't be read count as an average file
+	avg := 1.0
+	if n := len(files) - len(unknown); n > 0 {
+		avg = t.totalWeight / float64(n)
+	}
+	for _, p := range unknown {
+		t.weights[p] = avg
+		t.totalWeight += avg
+	}
+
+	// Redraw often on a terminal, rarely when logging to a file
+	t.interval = 10 * time.Second
+	if st, err := os.Stderr.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+		t.interval = time.Second
+	}
+	t.start = time.Now()
+	t.last = t.start
+	t.lastReport = t.start
+	return t
+}
+
+// pixelWeight returns width*height from the file header, or 0
+func pixelWeight(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0
+	}
+	return float64(cfg.Width) * float64(cfg.Height)
+}
+
+// complete records a finished file and prints a progress line when due
+func (t *etaTracker) complete(path string) {
+	now := time.Now()
+	w := t.weights[path]
+	t.done++
+	t.doneWeight += w
+
+	if dt := now.Sub(t.last).Seconds(); dt > 0 {
+		sample := w / dt
+		if t.rate == 0 {
+			sample = t.doneWeight / now.Sub(t.start).Seconds()
+			t.rate = sample
+		} else {
+			t.rate = etaSmoothing*sample + (1-etaSmoothing)*t.rate
+		}
+	}
+	t.last = now
+
+	if now.Sub(t.lastReport) >= t.interval && t.done < t.total {
+		t.lastReport = now
+		fmt.Fprintf(os.Stderr, "[ETA]\t%d/%d (%.0f%%), ~%s remaining\n", t.done, t.total, t.percent(), t.remaining())
+	}
+}
+
+// percent is the share of weighted work done
+func (t *etaTracker) percent() float64 {
+	if t.totalWeight == 0 {
+		return 100
+	}
+	return 100 * t.doneWeight / t.totalWeight
+}
+
+// remaining estimates the time left at the current smoothed rate
+func (t *etaTracker) remaining() time.Duration {
+	if t.rate <= 0 {
+		return 0
+	}
+	secs := (t.totalWeight - t.doneWeight) / t.rate
+	return time.Duration(secs * float64(time.Second)).Round(time.Second)
+}
+
+// progressEvent is one --progress-json line, written as each file finishes
+type progressEvent struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // ok, skipped or failed
+	Bytes  int64  `json:"bytes"`  // encoded output size; 0 unless ok
+	Reason string `json:"reason,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// progressJSON streams newline-delimited progressEvents
+type progressJSON struct {
+	enc *json.Encoder
+}
+
+func newProgressJSON(w io.Writer) *progressJSON {
+	return &progressJSON{enc: json.NewEncoder(w)}
+}
+
+// emit writes the event for one pool result
+func (p *progressJSON) emit(r convertResult) {
+	ev := progressEvent{Path: r.path, Status: "ok", Bytes: r.stats.outBytes}
+	switch {
+	case errors.Is(r.err, errSkipped):
+		ev = progressEvent{Path: r.path, Status: "skipped", Reason: r.stats.skipReason}
+	case r.err != nil:
+		ev = progressEvent{Path: r.path, Status: "failed", Code: errorCode(r.err), Error: r.err.Error()}
+	}
+	p.enc.Encode(ev)
+}
+
+// progressSummary is the final --progress-json line, written after every file
+type progressSummary struct {
+	Status  string          `json:"status"` // always summary
+	Savings []savingsBucket `json:"savings"`
+}
+
+// summary writes the end-of-run line with the savings histogram buckets
+func (p *progressJSON) summary(h *savingsHistogram) {
+	p.enc.Encode(progressSummary{Status: "summary", Savings: h.buckets()})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// overwriteDecision is the answer to "output exists, what now?"
+type overwriteDecision int
+
+const (
+	overwriteSkip overwriteDecision = iota
+	overwriteYes
+	overwriteIfSmaller
+)
+
+// overwritePrompter asks on the terminal whether to replace existing outputs.
+// Answering "a" overwrites this and every later file without asking again.
+type overwritePrompter struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+	all bool
+}
+
+func newOverwritePrompter(in io.Reader, out io.Writer) *overwritePrompter {
+	return &overwritePrompter{in: bufio.NewReader(in), out: out}
+}
+
+// decide prompts for outPath until a valid answer is given. EOF on input
+// is treated as skip.
+func (p *overwritePrompter) decide(outPath string) overwriteDecision {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.all {
+		return overwriteYes
+	}
+	for {
+		fmt.Fprintf(p.out, "%s exists. [s]kip, [o]verwrite, overwrite if s[m]aller, overwrite [a]ll? ", outPath)
+		line, err := p.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "s", "skip":
+			return overwriteSkip
+		case "o", "overwrite":
+			return overwriteYes
+		case "m", "smaller":
+			return overwriteIfSmaller
+		case "a", "all":
+			p.all = true
+			return overwriteYes
+		}
+		if err != nil {
+			fmt.Fprintln(p.out)
+			return overwriteSkip
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// maxQuantizeSamples bounds how many pixels feed the median cut
+const maxQuantizeSamples = 1 << 16
+
+// medianCutPalette builds a palette of up to n colours from img by
+// repeatedly splitting the box with the widest channel range at its median
+func medianCutPalette(img image.Image, n int) color.Palette {
+	boxes := medianCutBoxes(img, n)
+	if len(boxes) == 0 {
+		return color.Palette{color.Transparent}
+	}
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		palette = append(palette, boxAverage(box))
+	}
+	return palette
+}
+
+// medianCutBoxes samples img and splits the samples into up to n boxes of
+// similar colours; it returns nil for an empty image
+func medianCutBoxes(img image.Image, n int) [][][4]uint8 {
+	b := img.Bounds()
+	step := 1
+	if total := b.Dx() * b.Dy(); total > maxQuantizeSamples {
+		step = total / maxQuantizeSamples
+	}
+
+	var samples [][4]uint8
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if i%step == 0 {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				samples = append(samples, [4]uint8{c.R, c.G, c.B, c.A})
+			}
+			i++
+		}
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+
+	boxes := [][][4]uint8{samples}
+	for len(boxes) < n {
+		// Pick the box with the widest single-channel range
+		best, bestCh, bestRange := -1, 0, 0
+		for bi, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, r := widestChannel(box)
+			if r > bestRange {
+				best, bestCh, bestRange = bi, ch, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		sortSamples(box, bestCh)
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+	return boxes
+}
+
+// boxAverage returns the mean colour of a median-cut box
+func boxAverage(box [][4]uint8) color.RGBA {
+	var sum [4]int
+	for _, s := range box {
+		for ch := 0; ch < 4; ch++ {
+			sum[ch] += int(s[ch])
+		}
+	}
+	n := len(box)
+	return color.RGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: uint8(sum[3] / n)}
+}
+
+// sortSamples orders box by channel ch, breaking ties on the remaining
+// channels so the split, and the palette, never depend on how the sort
+// algorithm treats equal keys
+func sortSamples(box [][4]uint8, ch int) {
+	sort.Slice(box, func(a, b int) bool {
+		if box[a][ch] != box[b][ch] {
+			return box[a][ch] < box[b][ch]
+		}
+		return bytes.Compare(box[a][:], box[b][:]) < 0
+	})
+}
+
+// widestChannel returns the channel index with the largest value range
+func widestChannel(box [][4]uint8) (int, int) {
+	lo := [4]uint8{255, 255, 255, 255}
+	var hi [4]uint8
+	for _, s := range box {
+		for ch := 0; ch < 4; ch++ {
+			lo[ch] = min(lo[ch], s[ch])
+			hi[ch] = max(hi[ch], s[ch])
+		}
+	}
+	best, bestRange := 0, -1
+	for ch := 0; ch < 4; ch++ {
+		if r := int(hi[ch]) - int(lo[ch]); r > bestRange {
+			best, bestRange = ch, r
+		}
+	}
+	return best, bestRange
+}
+
+// quantizeImage reduces img to at most n colours, optionally spreading the
+// error with Floyd–Steinberg dithering to avoid banding in gradients.
+// Nothing here is randomized: sampling is a fixed stride, the median cut
+// orders samples totally and the dither scans in raster order, so the same
+// input always gives byte-identical output and no seed is needed.
+func quantizeImage(img image.Image, n int, dither bool) image.Image {
+	palette := medianCutPalette(img, n)
+	dst := image.NewPaletted(img.Bounds(), palette)
+	if dither {
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), img, img.Bounds().Min)
+	} else {
+		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return dst
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// recipe is the pipeline description read by --recipe. Every field is
+// optional; a nil field leaves the option at its flag value.
+type recipe struct {
+	Trim *struct {
+		Enabled      *bool   `json:"enabled"`
+		Threshold    *uint8  `json:"threshold"`
+		Edges        *string `json:"edges"`
+		IgnoreSpecks *int    `json:"ignoreSpecks"`
+		Symmetric    *bool   `json:"symmetric"`
+		EmptyPolicy  *string `json:"emptyPolicy"`
+		EmitBounds   *bool   `json:"emitBounds"`
+	} `json:"trim"`
+	Resize *struct {
+		Width        *int  `json:"width"`
+		Height       *int  `json:"height"`
+		MinShortSide *int  `json:"minShortSide"`
+		Progressive  *bool `json:"progressive"`
+	} `json:"resize"`
+	Format *struct {
+		Lossless         *bool     `json:"lossless"`
+		Quality          *float32  `json:"quality"`
+		Qualities        []float32 `json:"qualities"`
+		MinQuality       *float32  `json:"minQuality"`
+		LosslessMaxBytes *int64    `json:"losslessMaxBytes"`
+		LosslessInclude  []string  `json:"losslessInclude"`
+		Colors           *int      `json:"colors"`
+		Dither           *bool     `json:"dither"`
+	} `json:"format"`
+	Naming *struct {
+		Template           *string `json:"template"`
+		StripAllExtensions *bool   `json:"stripAllExtensions"`
+		OutputDir          *string `json:"outputDir"`
+		OnConflict         *string `json:"onConflict"`
+	} `json:"naming"`
+	Derive *struct {
+		Widths      []int  `json:"widths"`
+		Fallback    string `json:"fallback"`
+		Placeholder int    `json:"placeholder"`
+	} `json:"derive"`
+	Thumbnail *int `json:"thumbnail"`
+}
+
+// setFromRecipe copies v into dst unless v is nil or flag was given explicitly
+func setFromRecipe[T any](flags *pflag.FlagSet, flag string, v *T, dst *T) {
+	if v != nil && !flags.Changed(flag) {
+		*dst = *v
+	}
+}
+
+// applyRecipe reads a --recipe file and copies its values into dst for
+// every flag the user didn't set. Unknown keys are rejected so typos
+// don't silently fall back to defaults.
+func applyRecipe(path string, dst *convertOptions, flags *pflag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("recipe: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var r recipe
+	if err := dec.Decode(&r); err != nil {
+		return fmt.Errorf("recipe %s: %w", path, err)
+	}
+
+	if t := r.Trim; t != nil {
+		setFromRecipe(flags, "trim", t.Enabled, &dst.trim)
+		setFromRecipe(flags, "trim-threshold", t.Threshold, &dst.trimThreshold)
+		setFromRecipe(flags, "trim-edges", t.Edges, &dst.trimEdges)
+		setFromRecipe(flags, "trim-ignore-specks", t.IgnoreSpecks, &dst.trimIgnoreSpecks)
+		setFromRecipe(flags, "trim-symmetric", t.Symmetric, &dst.trimSymmetric)
+		setFromRecipe(flags, "trim-empty-policy", t.EmptyPolicy, &dst.trimEmptyPolicy)
+		setFromRecipe(flags, "emit-trim-bounds", t.EmitBounds, &dst.emitTrimBounds)
+	}
+	if rs := r.Resize; rs != nil {
+		setFromRecipe(flags, "width", rs.Width, &dst.maxWidth)
+		setFromRecipe(flags, "height", rs.Height, &dst.maxHeight)
+		setFromRecipe(flags, "min-short-side", rs.MinShortSide, &dst.minShortSide)
+		setFromRecipe(flags, "progressive-downscale", rs.Progressive, &dst.progressiveDownscale)
+	}
+	if f := r.Format; f != nil {
+		setFromRecipe(flags, "lossless", f.Lossless, &dst.lossless)
+		setFromRecipe(flags, "quality", f.Quality, &dst.quality)
+		setFromRecipe(flags, "min-quality", f.MinQuality, &dst.minQuality)
+		setFromRecipe(flags, "lossless-max-bytes", f.LosslessMaxBytes, &dst.losslessMaxBytes)
+		setFromRecipe(flags, "colors", f.Colors, &dst.colors)
+		setFromRecipe(flags, "dither", f.Dither, &dst.dither)
+		if f.LosslessInclude != nil && !flags.Changed("lossless-include") {
+			dst.losslessInclude = f.LosslessInclude
+		}
+		// Quality tiers go through the same parsing and checks as --qualities
+		if len(f.Qualities) > 0 && !flags.Changed("qualities") {
+			parts := make([]string, len(f.Qualities))
+			for i, q := range f.Qualities {
+				parts[i] = strconv.FormatFloat(float64(q), 'f', -1, 32)
+			}
+			dst.qualityList = strings.Join(parts, ",")
+		}
+	}
+	if n := r.Naming; n != nil {
+		setFromRecipe(flags, "name-template", n.Template, &dst.nameTemplate)
+		setFromRecipe(flags, "strip-all-extensions", n.StripAllExtensions, &dst.stripAllExtensions)
+		setFromRecipe(flags, "output-dir", n.OutputDir, &dst.outputDir)
+		setFromRecipe(flags, "on-conflict", n.OnConflict, &dst.onConflict)
+	}
+	// The derived set is rendered as a --derive spec so parseDeriveSpec validates it
+	if d := r.Derive; d != nil && !flags.Changed("derive") {
+		widths := make([]string, len(d.Widths))
+		for i, w := range d.Widths {
+			widths[i] = strconv.Itoa(w)
+		}
+		dst.derive = fmt.Sprintf("widths=%s;fallback=%s;placeholder=%d", strings.Join(widths, ","), d.Fallback, d.Placeholder)
+	}
+	setFromRecipe(flags, "thumbnail", r.Thumbnail, &dst.thumbnailPercent)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestFitBox(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		boxW, boxH   int
+		wantW, wantH int
+	}{
+		{"landscape", 4000, 3000, 800, 800, 800, 600},
+		{"portrait", 3000, 4000, 800, 800, 600, 800},
+		{"square", 1000, 1000, 300, 200, 200, 200},
+		{"same ratio", 1600, 900, 640, 360, 640, 360},
+		{"upscale", 100, 50, 800, 800, 800, 400},
+		{"upscale portrait", 30, 90, 200, 300, 100, 300},
+		{"rounding", 1000, 333, 500, 500, 500, 167},
+		{"rounding portrait", 333, 1000, 500, 500, 167, 500},
+		{"thin", 10000, 1, 100, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		w, h := fitBox(tt.w, tt.h, tt.boxW, tt.boxH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("%s: fitBox(%d, %d, %d, %d) = %dx%d, want %dx%d", tt.name, tt.w, tt.h, tt.boxW, tt.boxH, w, h, tt.wantW, tt.wantH)
+		}
+		if w < 1 || h < 1 || w > tt.boxW || h > tt.boxH {
+			t.Errorf("%s: %dx%d does not fit inside %dx%d", tt.name, w, h, tt.boxW, tt.boxH)
+		}
+		if w != tt.boxW && h != tt.boxH {
+			t.Errorf("%s: %dx%d touches no side of %dx%d", tt.name, w, h, tt.boxW, tt.boxH)
+		}
+	}
+}
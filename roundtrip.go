@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	webp "github.com/chai2010/webp"
+)
+
+// verifyRoundtrip decodes the WebP written to path and compares it with
+// want, the image handed to the encoder. It fails when any channel of a
+// visible pixel differs by more than tolerance (0-255); the colour under
+// fully transparent pixels is ignored, since the encoder may discard it.
+func verifyRoundtrip(path string, want image.Image, tolerance int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errRoundtrip, err)
+	}
+	got, err := webp.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%w: decode output: %w", errRoundtrip, err)
+	}
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Dx() != gb.Dx() || wb.Dy() != gb.Dy() {
+		return fmt.Errorf("%w: output is %dx%d, expected %dx%d", errRoundtrip, gb.Dx(), gb.Dy(), wb.Dx(), wb.Dy())
+	}
+
+	worst, worstAt := 0, image.Point{}
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			d := absDiff(w.A, g.A)
+			if w.A != 0 || g.A != 0 {
+				d = max(d, absDiff(w.R, g.R), absDiff(w.G, g.G), absDiff(w.B, g.B))
+			}
+			if d > worst {
+				worst, worstAt = d, image.Pt(x, y)
+			}
+		}
+	}
+	if worst > tolerance {
+		return fmt.Errorf("%w: pixel (%d,%d) differs by %d, tolerance %d", errRoundtrip, worstAt.X, worstAt.Y, worst, tolerance)
+	}
+	return nil
+}
+
+// absDiff returns |a-b|
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
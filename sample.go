@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// runSample converts a single representative file in memory and prints a
+// detailed report. Nothing is written to disk.
+func runSample(files []string) error {
+	path := opts.sampleFile
+	if path == "" {
+		var largest int64 = -1
+		for _, f := range files {
+			st, err := os.Stat(f)
+			if err != nil {
+				continue
+			}
+			if st.Size() > largest {
+				largest = st.Size()
+				path = f
+			}
+		}
+		if path == "" {
+			fmt.Fprintln(runLog, "No images found to sample.")
+			return nil
+		}
+	}
+
+	sampleOpts := opts
+	sampleOpts.noWrite = true
+	sampleOpts.overwrite = true
+	sampleOpts.deleteOriginal = false
+
+	start := time.Now()
+	stats, err := convertOne(path, sampleOpts)
+	elapsed := time.Since(start)
+	if err != nil {
+		if stats.skipReason != "" {
+			return fmt.Errorf("sample %s skipped: %s", path, stats.skipReason)
+		}
+		return fmt.Errorf("sample %s [%s]: %w", path, errorCode(err), err)
+	}
+
+	mode := fmt.Sprintf("lossy q=%.0f", stats.quality)
+	if stats.lossless {
+		mode = "lossless"
+	}
+	if stats.downgraded {
+		mode += fmt.Sprintf(" (downgraded from %d byte lossless)", stats.losslessBytes)
+	}
+
+	fmt.Fprintf(runLog, "Sample:     %s\n", path)
+	fmt.Fprintf(runLog, "Source:     %s %dx%d, %d bytes\n", stats.format, stats.srcWidth, stats.srcHeight, stats.srcBytes)
+	fmt.Fprintf(runLog, "Trimmed:    %t\n", stats.trimmed)
+	fmt.Fprintf(runLog, "Resized:    %t\n", stats.resized)
+	fmt.Fprintf(runLog, "Output:     webp %dx%d, %d bytes\n", stats.outWidth, stats.outHeight, stats.outBytes)
+	fmt.Fprintf(runLog, "Encoding:   %s\n", mode)
+	if stats.srcBytes > 0 {
+		fmt.Fprintf(runLog, "Saving:     %.1f%%\n", 100*float64(stats.srcBytes-stats.outBytes)/float64(stats.srcBytes))
+	}
+	fmt.Fprintf(runLog, "Time:       %s\n", elapsed.Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// savingsBuckets is the number of 10%-wide buckets from 0% to 100% saved;
+// outputs larger than their source go in an extra "grew" bucket
+const savingsBuckets = 10
+
+// savingsBarWidth is the length of the longest histogram bar
+const savingsBarWidth = 40
+
+// savingsHistogram counts converted files by percent of bytes saved, so a
+// run that is half great photos and half already-optimized PNGs shows two
+// humps instead of one misleading average
+type savingsHistogram struct {
+	grew   int
+	counts [savingsBuckets]int
+}
+
+// add records one successful conversion
+func (h *savingsHistogram) add(stats convertStats) {
+	if stats.srcBytes <= 0 {
+		return
+	}
+	saved := 100 * float64(stats.srcBytes-stats.outBytes) / float64(stats.srcBytes)
+	if saved < 0 {
+		h.grew++
+		return
+	}
+	i := int(saved / (100 / savingsBuckets))
+	if i >= savingsBuckets {
+		i = savingsBuckets - 1
+	}
+	h.counts[i]++
+}
+
+// savingsBucket is one histogram row, as printed and in the --progress-json summary
+type savingsBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// buckets returns every row in order, starting with the "grew" bucket
+func (h *savingsHistogram) buckets() []savingsBucket {
+	rows := []savingsBucket{{Label: "grew", Count: h.grew}}
+	width := 100 / savingsBuckets
+	for i, n := range h.counts {
+		rows = append(rows, savingsBucket{Label: fmt.Sprintf("%d-%d%%", i*width, (i+1)*width), Count: n})
+	}
+	return rows
+}
+
+// print writes an ASCII histogram with bars scaled to the largest bucket
+func (h *savingsHistogram) print(w io.Writer) {
+	rows := h.buckets()
+	peak := 0
+	for _, r := range rows {
+		peak = max(peak, r.Count)
+	}
+	if peak == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Saved:")
+	for _, r := range rows {
+		bar := strings.Repeat("#", (r.Count*savingsBarWidth+peak-1)/peak)
+		fmt.Fprintf(w, "\t%-8s %-*s %d\n", r.Label, savingsBarWidth, bar, r.Count)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeClass is one --size-class entry: sources smaller than limit bytes
+// (any size when limit is 0) are encoded losslessly or at quality
+type sizeClass struct {
+	label    string
+	limit    int64
+	lossless bool
+	quality  float32
+}
+
+// byteUnits are the suffixes accepted by parseByteSize, longest first
+var byteUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize parses "512", "100KB" or "1.5MB" (binary units)
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	scale := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(scale)), nil
+}
+
+// parseSizeClasses parses "100KB=lossless;2MB=q85;*=q75". Each entry
+// applies to sources below its limit that no earlier entry took; "*"
+// matches everything left and must come last. Limits must increase.
+func parseSizeClasses(spec string) ([]sizeClass, error) {
+	var classes []sizeClass
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		limit, setting, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("size-class: %q is not LIMIT=SETTING", part)
+		}
+		if len(classes) > 0 && classes[len(classes)-1].limit == 0 {
+			return nil, fmt.Errorf("size-class: %q follows the catch-all *", part)
+		}
+		c := sizeClass{label: strings.TrimSpace(part)}
+		if limit = strings.TrimSpace(limit); limit != "*" {
+			n, err := parseByteSize(limit)
+			if err != nil {
+				return nil, fmt.Errorf("size-class: %w", err)
+			}
+			if len(classes) > 0 && n <= classes[len(classes)-1].limit {
+				return nil, fmt.Errorf("size-class: limits must increase (%s)", limit)
+			}
+			c.limit = n
+		}
+		switch setting = strings.ToLower(strings.TrimSpace(setting)); {
+		case setting == "lossless":
+			c.lossless = true
+		case strings.HasPrefix(setting, "q"):
+			q, err := strconv.ParseFloat(setting[1:], 32)
+			if err != nil || q < 0 || q > 100 {
+				return nil, fmt.Errorf("size-class: invalid quality %q", setting)
+			}
+			c.quality = float32(q)
+		default:
+			return nil, fmt.Errorf("size-class: setting %q must be lossless or qNN", setting)
+		}
+		classes = append(classes, c)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("size-class: no classes given")
+	}
+	return classes, nil
+}
+
+// sizeClassFor returns the class for a source of size bytes, or nil when
+// none applies
+func sizeClassFor(classes []sizeClass, size int64) *sizeClass {
+	for i, c := range classes {
+		if c.limit == 0 || size < c.limit {
+			return &classes[i]
+		}
+	}
+	return nil
+}
+
+// apply sets the class's encoding on opts
+func (c *sizeClass) apply(opts *convertOptions) {
+	opts.lossless = c.lossless
+	if !c.lossless {
+		opts.quality = c.quality
+	}
+}
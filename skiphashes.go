@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// skipHashes is set by --skip-hashes: the SHA-256 of each known-bad source,
+// mapped to the rest of its line (a file name or note, possibly empty)
+var skipHashes map[string]string
+
+// loadSkipHashes reads a denylist of SHA-256 hashes, one per line. Text
+// after the hash is kept as a note, so sha256sum output works as is;
+// blank lines and lines starting with # are ignored.
+func loadSkipHashes(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("skip-hashes: %w", err)
+	}
+	defer f.Close()
+	hashes := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, note, _ := strings.Cut(line, " ")
+		hash = strings.ToLower(hash)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("skip-hashes %s:%d: %q is not a SHA-256 hash", path, n, hash)
+		}
+		hashes[hash] = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(note), "*"))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("skip-hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// skipHashReason describes a source whose hash is on the denylist
+func skipHashReason(note string) string {
+	if note == "" {
+		return "SHA-256 on --skip-hashes denylist"
+	}
+	return "SHA-256 on --skip-hashes denylist: " + note
+}
@@ -0,0 +1,56 @@
+package main
+
+import "image"
+
+// contentMask marks the non-transparent pixels of img that belong to an
+// 8-connected component of at least minSize pixels, so stray specks (dust,
+// compression noise) don't hold the trim bounds open. The mask is indexed
+// by (y-min.y)*width + (x-min.x).
+func contentMask(img image.Image, threshold uint8, minSize int) []bool {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	opaque := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			opaque[y*w+x] = !isTransparent(img.At(b.Min.X+x, b.Min.Y+y), threshold)
+		}
+	}
+
+	keep := make([]bool, w*h)
+	seen := make([]bool, w*h)
+	var component, stack []int
+	for start := range opaque {
+		if !opaque[start] || seen[start] {
+			continue
+		}
+		// Flood fill one component, remembering its pixels
+		component = component[:0]
+		stack = append(stack[:0], start)
+		seen[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			component = append(component, i)
+			x, y := i%w, i/w
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					j := ny*w + nx
+					if opaque[j] && !seen[j] {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		if len(component) >= minSize {
+			for _, i := range component {
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+	"github.com/spf13/cobra"
+)
+
+var spriteCmd = &cobra.Command{
+	Use:   "sprite SOURCE...",
+	Short: "Pack many small images into one WebP sprite sheet",
+	Long: `Packs the given images, and the images directly inside any given
+directories, into a single WebP atlas using shelf packing: sprites are
+sorted tallest first and laid out in rows no wider than --max-width.
+A JSON file next to the atlas records each sprite's rectangle, keyed by
+file name without extension.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSprite,
+}
+
+// spriteOpts holds the sprite subcommand flags
+var spriteOpts struct {
+	output   string
+	padding  int
+	maxWidth int
+	lossless bool
+	quality  float32
+}
+
+func init() {
+	spriteCmd.Flags().StringVarP(&spriteOpts.output, "output", "O", "sprite.webp", "Atlas path; the coordinates are written next to it as .json")
+	spriteCmd.Flags().IntVar(&spriteOpts.padding, "padding", 1, "Transparent pixels between sprites, against bleeding when scaled")
+	spriteCmd.Flags().IntVar(&spriteOpts.maxWidth, "max-width", 0, "Maximum atlas width (0 = roughly square)")
+	spriteCmd.Flags().BoolVar(&spriteOpts.lossless, "lossless", true, "Encode the atlas losslessly")
+	spriteCmd.Flags().Float32Var(&spriteOpts.quality, "quality", 90, "WebP quality with --lossless=false (0-100)")
+	rootCmd.AddCommand(spriteCmd)
+}
+
+// sprite is one packed input and its place in the atlas
+type sprite struct {
+	name string
+	img  image.Image
+	rect image.Rectangle
+}
+
+// spriteRect is one entry of the coordinates JSON
+type spriteRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// spriteSheet is the coordinates JSON written next to the atlas
+type spriteSheet struct {
+	Image   string                `json:"image"`
+	Width   int                   `json:"width"`
+	Height  int                   `json:"height"`
+	Sprites map[string]spriteRect `json:"sprites"`
+}
+
+func runSprite(cmd *cobra.Command, args []string) error {
+	if spriteOpts.quality < 0 || spriteOpts.quality > 100 {
+		return fmt.Errorf("quality must be between 0 and 100")
+	}
+	if spriteOpts.padding < 0 {
+		return fmt.Errorf("padding must not be negative")
+	}
+	if !strings.EqualFold(filepath.Ext(spriteOpts.output), ".webp") {
+		return fmt.Errorf("output must be a .webp path")
+	}
+
+	var paths []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		found, err := collectImageFiles(arg, false, nil)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, found...)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no images found")
+	}
+
+	sprites := make([]*sprite, 0, len(paths))
+	owners := map[string]string{}
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		if prev, ok := owners[name]; ok {
+			return fmt.Errorf("sprite name %q used by both %s and %s", name, prev, p)
+		}
+		owners[name] = p
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", p, err)
+		}
+		sprites = append(sprites, &sprite{name: name, img: img})
+	}
+
+	w, h := packShelves(sprites, spriteOpts.maxWidth, spriteOpts.padding)
+	atlas := image.NewNRGBA(image.Rect(0, 0, w, h))
+	sheet := spriteSheet{Image: filepath.Base(spriteOpts.output), Width: w, Height: h, Sprites: map[string]spriteRect{}}
+	for _, s := range sprites {
+		draw.Draw(atlas, s.rect, s.img, s.img.Bounds().Min, draw.Src)
+		sheet.Sprites[s.name] = spriteRect{X: s.rect.Min.X, Y: s.rect.Min.Y, Width: s.rect.Dx(), Height: s.rect.Dy()}
+	}
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, atlas, &webp.Options{Lossless: spriteOpts.lossless, Quality: spriteOpts.quality}); err != nil {
+		return fmt.Errorf("encode atlas: %w", err)
+	}
+	if dir := filepath.Dir(spriteOpts.output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(spriteOpts.output, buf.Bytes()); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sheet, "", "\t")
+	if err != nil {
+		return err
+	}
+	jsonPath := strings.TrimSuffix(spriteOpts.output, filepath.Ext(spriteOpts.output)) + ".json"
+	if err := writeFileAtomic(jsonPath, append(data, '\n')); err != nil {
+		return err
+	}
+	fmt.Printf("[OK]\t%s (%d sprites, %dx%d, %d bytes)\n", spriteOpts.output, len(sprites), w, h, buf.Len())
+	fmt.Printf("[OK]\t%s\n", jsonPath)
+	return nil
+}
+
+// packShelves places sprites in rows, tallest first, each row no wider than
+// maxWidth (or about the square root of the total area when maxWidth is 0),
+// and returns the atlas size. A sprite wider than maxWidth gets a row to
+// itself.
+func packShelves(sprites []*sprite, maxWidth, padding int) (width, height int) {
+	sort.SliceStable(sprites, func(i, j int) bool {
+		hi, hj := sprites[i].img.Bounds().Dy(), sprites[j].img.Bounds().Dy()
+		if hi != hj {
+			return hi > hj
+		}
+		return sprites[i].name < sprites[j].name
+	})
+	if maxWidth <= 0 {
+		area, widest := 0, 0
+		for _, s := range sprites {
+			b := s.img.Bounds()
+			area += (b.Dx() + padding) * (b.Dy() + padding)
+			widest = max(widest, b.Dx())
+		}
+		maxWidth = max(widest, int(math.Ceil(math.Sqrt(float64(area)))))
+	}
+
+	x, y, shelf := 0, 0, 0
+	for _, s := range sprites {
+		b := s.img.Bounds()
+		if x > 0 && x+b.Dx() > maxWidth {
+			x, y, shelf = 0, y+shelf+padding, 0
+		}
+		s.rect = image.Rect(x, y, x+b.Dx(), y+b.Dy())
+		x += b.Dx() + padding
+		shelf = max(shelf, b.Dy())
+		width = max(width, s.rect.Max.X)
+	}
+	return width, y + shelf
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+)
+
+// stdoutPath is the output path writeWebp treats as standard output
+const stdoutPath = "-"
+
+// runToStdout converts a single input (a file, an http(s) URL, or "-" for
+// stdin) and writes the WebP to stdout. Nothing else is printed to stdout.
+func runToStdout(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("to-stdout needs exactly one input (a file, URL or - for stdin)")
+	}
+	if len(opts.qualities) > 0 {
+		return fmt.Errorf("to-stdout cannot be combined with --qualities")
+	}
+	input := args[0]
+
+	var data []byte
+	var err error
+	switch {
+	case input == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case isURL(input):
+		data, _, err = fetchURL(&http.Client{Timeout: opts.urlTimeout}, input)
+	default:
+		data, err = os.ReadFile(input)
+		if err != nil {
+			err = openError(err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, decodeError(err))
+	}
+	stats := convertStats{format: format, srcBytes: int64(len(data))}
+	if err := writeWebp(img, stdoutPath, opts, &stats); err != nil {
+		if stats.skipReason != "" {
+			return fmt.Errorf("%s skipped: %s", input, stats.skipReason)
+		}
+		return fmt.Errorf("%s [%s]: %w", input, errorCode(err), err)
+	}
+	return nil
+}
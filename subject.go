@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// subjectOutlierShare is the share of edge pixels --auto-subject-crop lets
+// fall outside the subject on each side, so sensor noise, dust or a stray
+// shadow near the border doesn't stretch the box
+const subjectOutlierShare = 0.005
+
+// subjectBounds returns the bounding box of high edge energy in img: the
+// pixels whose Sobel gradient magnitude (|gx|+|gy| on 8-bit luma, 0-2040)
+// is at least threshold, less subjectOutlierShare of them on each side.
+// It is empty when no pixel reaches the threshold.
+func subjectBounds(img image.Image, threshold int) image.Rectangle {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Rect, img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return image.Rectangle{}
+	}
+
+	at := func(x, y int) int { return int(gray.Pix[y*gray.Stride+x]) }
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	rows := make([]int, h)
+	cols := make([]int, w)
+	total := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			if abs(gx)+abs(gy) >= threshold {
+				rows[y]++
+				cols[x]++
+				total++
+			}
+		}
+	}
+	if total == 0 {
+		return image.Rectangle{}
+	}
+
+	skip := int(float64(total) * subjectOutlierShare)
+	minY, maxY := profileSpan(rows, skip)
+	minX, maxX := profileSpan(cols, skip)
+	return image.Rect(minX, minY, maxX, maxY).Add(b.Min)
+}
+
+// profileSpan returns the half-open range of indexes left once up to skip
+// counts are dropped from each end of profile
+func profileSpan(profile []int, skip int) (lo, hi int) {
+	lo, hi = 0, len(profile)
+	for n := 0; lo < hi && n+profile[lo] <= skip; lo++ {
+		n += profile[lo]
+	}
+	for n := 0; hi > lo && n+profile[hi-1] <= skip; hi-- {
+		n += profile[hi-1]
+	}
+	return lo, hi
+}
+
+// cropToSubject crops img to its subjectBounds widened by margin pixels on
+// every side, within the image, and returns the kept region in img's
+// coordinates. kept is img's bounds when no subject was found or the box
+// already covers the whole image.
+func cropToSubject(img image.Image, threshold, margin int) (out image.Image, kept image.Rectangle) {
+	b := img.Bounds()
+	r := subjectBounds(img, threshold)
+	if r.Empty() {
+		return img, b
+	}
+	r = r.Inset(-margin).Intersect(b)
+	if r == b {
+		return img, b
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst, r
+}
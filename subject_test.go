@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	webp "github.com/chai2010/webp"
+)
+
+func TestSubjectCropTrimBounds(t *testing.T) {
+	// A black square on white, inside a 10px transparent border
+	dir := t.TempDir()
+	src := filepath.Join(dir, "product.png")
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for y := 10; y < 90; y++ {
+		for x := 10; x < 90; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if x >= 40 && x < 60 && y >= 40 && y < 60 {
+				c = color.NRGBA{0, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts.directory = dir
+
+	o := opts
+	o.lossless = true
+	o.trim = true
+	o.emitTrimBounds = true
+	o.autoSubjectCrop = true
+	o.subjectThreshold = 96
+	o.stages = pipelineStages
+	if o.edges, err = parseTrimEdges("all"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := convertOne(src, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.subjectCropped {
+		t.Fatal("subject crop did not crop")
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(stats.outPath, ".webp") + ".trim.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tb trimBounds
+	if err := json.Unmarshal(data, &tb); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Open(stats.outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cfg, err := webp.DecodeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tb.TrimmedWidth != cfg.Width || tb.TrimmedHeight != cfg.Height {
+		t.Errorf("trim bounds say %dx%d, output is %dx%d", tb.TrimmedWidth, tb.TrimmedHeight, cfg.Width, cfg.Height)
+	}
+	if tb.MinX > 40 || tb.MinY > 40 || tb.MaxX < 60 || tb.MaxY < 60 || tb.MinX <= 10 || tb.MaxX >= 90 {
+		t.Errorf("kept region %d,%d-%d,%d does not frame the square at 40,40-60,60", tb.MinX, tb.MinY, tb.MaxX, tb.MaxY)
+	}
+	if tb.OriginalWidth != 100 || tb.OriginalHeight != 100 {
+		t.Errorf("original size %dx%d, want 100x100", tb.OriginalWidth, tb.OriginalHeight)
+	}
+}
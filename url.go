@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxURLBytes caps how much of a remote response body is read
+const maxURLBytes = 100 << 20
+
+// isURL reports whether arg looks like an http(s) URL
+func isURL(arg string) bool {
+	lower := strings.ToLower(arg)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// urlSources maps each downloaded URL body, saved to a temporary file, to
+// the URL it came from; set by runURLs before converting
+var urlSources map[string]string
+
+// sourceName is what a source is reported and recorded as: its URL for a
+// download, otherwise its path
+func sourceName(p string) string {
+	if u, ok := urlSources[p]; ok {
+		return u
+	}
+	return p
+}
+
+// runURLs fetches each URL argument into a temporary file and converts
+// them like files from --directory, with outputs named after the URL and
+// written directly under --output-dir (or --directory). The error counts
+// the URLs that failed.
+func runURLs(urls []string, progress *progressJSON) error {
+	for _, u := range urls {
+		if !isURL(u) {
+			return fmt.Errorf("unsupported argument %q (only http:// and https:// URLs are accepted)", u)
+		}
+	}
+
+	if err := checkWritable(outputRoot()); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "image-convert-url-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	converted := 0
+	failed := 0
+	var savings savingsHistogram
+	report := func(u string, stats convertStats, err error) {
+		if progress != nil {
+			progress.emit(convertResult{path: u, stats: stats, err: err})
+		}
+		switch {
+		case errors.Is(err, errSkipped):
+			printSkip(u, stats.skipReason)
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "[FAIL]\t%s [%s]: %v\n", u, errorCode(err), err)
+		default:
+			converted++
+			savings.add(stats)
+			if err := sourceHashes.record(u, stats); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			fmt.Fprintf(runLog, "[OK]\t%s -> %s\n", u, stats.outPath)
+		}
+	}
+
+	client := &http.Client{Timeout: opts.urlTimeout}
+	urlSources = make(map[string]string, len(urls))
+	var files []string
+	for i, u := range urls {
+		p, err := downloadURL(client, u, filepath.Join(tmp, strconv.Itoa(i)))
+		if err != nil {
+			report(u, convertStats{}, err)
+			continue
+		}
+		urlSources[p] = u
+		files = append(files, p)
+	}
+
+	// Two URLs with the same file name would write the same output
+	files, conflicted, err := resolveOutputConflicts(files, opts.onConflict)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicted {
+		printSkip(sourceName(c.path), fmt.Sprintf("output %s already claimed by %s", c.outPath, sourceName(c.owner)))
+	}
+
+	// The downloads are temporary, so there is no original to delete
+	urlOpts := opts
+	urlOpts.deleteOriginal = false
+	for _, p := range files {
+		stats, err := convertOne(p, urlOpts)
+		report(sourceName(p), stats, err)
+	}
+
+	fmt.Fprintf(runLog, "Done. Converted: %d, Failed: %d\n", converted, failed)
+	if progress != nil {
+		progress.summary(&savings)
+	}
+	if err := sourceHashes.write(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d URL(s) failed", failed, len(urls))
+	}
+	return nil
+}
+
+// downloadURL fetches rawURL into dir, named after the last path segment
+// of the final (post-redirect) URL. The name gets the extension of the
+// detected format when it has no image extension, since GIF, TIFF and
+// WebP handling goes by extension.
+func downloadURL(client *http.Client, rawURL, dir string) (string, error) {
+	data, finalURL, err := fetchURL(client, rawURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(finalURL.Path)
+	if stem := outputStem(name); stem == "" || stem == "." || stem == "/" {
+		name = "image"
+	}
+	if !isImageExt(name) {
+		if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// fetchURL downloads an image body, returning it with the final URL after
+// redirects. Non-image content types are rejected.
+func fetchURL(client *http.Client, rawURL string) ([]byte, *url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch: unexpected status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err == nil && !strings.HasPrefix(mediaType, "image/") && mediaType != "application/octet-stream" {
+			return nil, nil, fmt.Errorf("unsupported content type %q", mediaType)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxURLBytes+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read body: %w", err)
+	}
+	if len(data) > maxURLBytes {
+		return nil, nil, fmt.Errorf("response larger than %d bytes", maxURLBytes)
+	}
+	return data, resp.Request.URL, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchPoll is how often --watch checks the source for changes
+const watchPoll = 250 * time.Millisecond
+
+// watchSettle is how long the source must stay unchanged before it is
+// converted, so an editor's partial writes aren't picked up
+const watchSettle = 300 * time.Millisecond
+
+// runWatch converts path, then converts it again every time it changes
+// until the process is interrupted
+func runWatch(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return fmt.Errorf("watch takes a single file, %s is a directory", path)
+	}
+	if err := checkWritable(outputRoot()); err != nil {
+		return err
+	}
+
+	// Every change replaces the previous output
+	watchOpts := opts
+	watchOpts.overwrite = true
+
+	fmt.Fprintf(runLog, "Watching %s (Ctrl-C to stop)\n", path)
+	last := st
+	convertWatched(path, watchOpts)
+	for {
+		time.Sleep(watchPoll)
+		st, err := os.Stat(path)
+		if err != nil {
+			// Editors often replace a file by delete and rename
+			continue
+		}
+		if st.ModTime().Equal(last.ModTime()) && st.Size() == last.Size() {
+			continue
+		}
+		// Wait for the writer to finish
+		for {
+			time.Sleep(watchSettle)
+			next, err := os.Stat(path)
+			if err != nil || (next.ModTime().Equal(st.ModTime()) && next.Size() == st.Size()) {
+				break
+			}
+			st = next
+		}
+		last = st
+		convertWatched(path, watchOpts)
+	}
+}
+
+// convertWatched converts path once and logs the result with a timestamp
+func convertWatched(path string, opts convertOptions) {
+	start := time.Now()
+	stats, err := convertOne(path, opts)
+	stamp := start.Format("15:04:05")
+	switch {
+	case errors.Is(err, errSkipped):
+		fmt.Fprint(runLog, stamp, " ")
+		printSkip(path, stats.skipReason)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s [FAIL]\t%s [%s]: %v\n", stamp, path, errorCode(err), err)
+	default:
+		fmt.Fprintf(runLog, "%s [OK]\t%s -> %s (%d bytes, %s)\n", stamp, path, stats.outPath, stats.outBytes, time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	webp "github.com/chai2010/webp"
+)
+
+// --xmp-quality and --xmp-target-bytes read per-file export hints that
+// design tools write into the source's XMP packet. The packet is found in
+// JPEG (APP1 "http://ns.adobe.com/xap/1.0/"), PNG (iTXt "XML:com.adobe.xmp",
+// compressed or not), WebP (XMP chunk) and TIFF (tag 700) sources. GIF,
+// BMP and extended XMP split across several JPEG segments are not read, so
+// those files use the global settings.
+
+// tiffXMP is the TIFF tag holding an XMP packet
+const tiffXMP = 700
+
+// xmpFieldRe is the accepted form of an XMP field name, prefix:Name
+var xmpFieldRe = regexp.MustCompile(`^[A-Za-z_][\w.-]*:[A-Za-z_][\w.-]*$`)
+
+// readSourceXMP returns the XMP packet embedded in the image at path, or
+// nil if the format carries none or the file has no packet
+func readSourceXMP(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var magic [12]byte
+	n, _ := io.ReadFull(f, magic[:])
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := string(magic[:n])
+	switch {
+	case strings.HasPrefix(head, "\xFF\xD8"):
+		return readJPEGXMP(f)
+	case strings.HasPrefix(head, "\x89PNG\r\n\x1a\n"):
+		return readPNGXMP(f)
+	case strings.HasPrefix(head, "RIFF") && len(head) == 12 && head[8:] == "WEBP":
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		xmp, err := webp.GetMetadata(data, "XMP")
+		if err != nil {
+			// Simple (VP8/VP8L only) files have no metadata chunks
+			return nil, nil
+		}
+		return xmp, nil
+	case strings.HasPrefix(head, "II*\x00"), strings.HasPrefix(head, "MM\x00*"):
+		ifd, err := readTIFFIFD(f)
+		if err != nil {
+			return nil, err
+		}
+		return ifd.entries[tiffXMP], nil
+	}
+	return nil, nil
+}
+
+// readJPEGXMP returns the standard XMP packet of a JPEG's APP1 segment
+func readJPEGXMP(r io.Reader) ([]byte, error) {
+	const ns = "http://ns.adobe.com/xap/1.0/\x00"
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errors.New("not a JPEG")
+	}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("bad JPEG marker")
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, nil
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, errors.New("bad JPEG segment length")
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(seg, []byte(ns)) {
+			return seg[len(ns):], nil
+		}
+	}
+}
+
+// readPNGXMP returns the text of a PNG's XMP iTXt chunk
+func readPNGXMP(r io.Reader) ([]byte, error) {
+	const keyword = "XML:com.adobe.xmp"
+	br := bufio.NewReader(r)
+	sig := make([]byte, 8)
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("not a PNG")
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		typ := string(hdr[4:8])
+		if typ == "IEND" {
+			return nil, nil
+		}
+		data := make([]byte, int(n)+4) // payload and CRC
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		data = data[:n]
+		if typ != "iTXt" || !bytes.HasPrefix(data, []byte(keyword+"\x00")) {
+			continue
+		}
+		// keyword, NUL, compression flag, method, language NUL, translated keyword NUL, text
+		rest := data[len(keyword)+1:]
+		if len(rest) < 2 {
+			return nil, errors.New("bad iTXt chunk")
+		}
+		compressed := rest[0] == 1
+		rest = rest[2:]
+		for range 2 {
+			i := bytes.IndexByte(rest, 0)
+			if i < 0 {
+				return nil, errors.New("bad iTXt chunk")
+			}
+			rest = rest[i+1:]
+		}
+		if !compressed {
+			return rest, nil
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+}
+
+// xmpField returns the value of field (prefix:Name) in an XMP packet,
+// written either as an attribute (prefix:Name="80") or as a simple
+// element (<prefix:Name>80</prefix:Name>)
+func xmpField(xmp []byte, field string) (string, bool) {
+	name := regexp.QuoteMeta(field)
+	attr := regexp.MustCompile(`\s` + name + `\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	if m := attr.FindSubmatch(xmp); m != nil {
+		return strings.TrimSpace(string(m[1]) + string(m[2])), true
+	}
+	elem := regexp.MustCompile(`<` + name + `>([^<]*)</` + name + `>`)
+	if m := elem.FindSubmatch(xmp); m != nil {
+		return strings.TrimSpace(string(m[1])), true
+	}
+	return "", false
+}
+
+// applyXMPHints overrides opts with the --xmp-quality and --xmp-target-bytes
+// fields of the source's XMP packet. Files without the fields keep opts;
+// a field that is present but invalid fails the file. note describes the
+// overrides applied.
+func applyXMPHints(path string, opts *convertOptions) (note string, err error) {
+	xmp, err := readSourceXMP(path)
+	if err != nil || xmp == nil {
+		return "", nil
+	}
+	var notes []string
+	if opts.xmpQualityField != "" {
+		if v, ok := xmpField(xmp, opts.xmpQualityField); ok {
+			q, err := strconv.ParseFloat(v, 32)
+			if err != nil || q < 0 || q > 100 {
+				return "", fmt.Errorf("XMP %s %q must be between 0 and 100", opts.xmpQualityField, v)
+			}
+			opts.quality = float32(q)
+			notes = append(notes, fmt.Sprintf("quality %g", q))
+		}
+	}
+	if opts.xmpTargetField != "" {
+		if v, ok := xmpField(xmp, opts.xmpTargetField); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				return "", fmt.Errorf("XMP %s %q must be a positive byte count", opts.xmpTargetField, v)
+			}
+			opts.targetBytes = n
+			notes = append(notes, fmt.Sprintf("target %d bytes", n))
+		}
+	}
+	if len(notes) == 0 {
+		return "", nil
+	}
+	return strings.Join(notes, ", ") + " from XMP", nil
+}